@@ -0,0 +1,240 @@
+// Package aacrypto implements the ReBIT scheme used to encrypt FI data in
+// transit: both sides exchange an ephemeral Curve25519 public key and a random
+// nonce in KeyMaterial, the ECDH shared secret is run through HKDF-SHA256
+// salted with the XOR of the two nonces, and the payload is sealed with
+// AES-256-GCM.
+package aacrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	CryptoAlg = "ECDH"
+	Curve     = "Curve25519"
+
+	nonceSize = 32
+	saltSize  = 20
+	ivSize    = 12
+	keySize   = 32
+
+	// KeyExpiry is how long the public key we send in /FI/request stays valid.
+	KeyExpiry = 24 * time.Hour
+
+	expiryLayout = "2006-01-02T15:04:05.000Z07:00"
+)
+
+type KeyMaterial struct {
+	CryptoAlg   string      `json:"cryptoAlg"`
+	Curve       string      `json:"curve"`
+	Params      string      `json:"params"`
+	DHPublicKey DHPublicKey `json:"DHPublicKey"`
+	Nonce       string      `json:"Nonce"`
+}
+
+type DHPublicKey struct {
+	Expiry     string `json:"expiry"`
+	Parameters string `json:"Parameters"`
+	KeyValue   string `json:"KeyValue"`
+}
+
+// KeyPair is one side of an FI data session. Ours is generated per
+// /FI/request and has to be kept until the data is fetched.
+type KeyPair struct {
+	private  *ecdh.PrivateKey
+	nonce    []byte
+	Material KeyMaterial
+}
+
+func GenerateKeyPair() (*KeyPair, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating key pair: %v", err)
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+
+	return newKeyPair(private, nonce, time.Now().Add(KeyExpiry))
+}
+
+// ImportKeyPair rebuilds a key pair from its exported private key and nonce,
+// as returned by Export.
+func ImportKeyPair(privateKey, nonce string, expiry time.Time) (*KeyPair, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding private key: %v", err)
+	}
+	private, err := ecdh.X25519().NewPrivateKey(rawKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+
+	rawNonce, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil || len(rawNonce) != nonceSize {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+
+	return newKeyPair(private, rawNonce, expiry)
+}
+
+func newKeyPair(private *ecdh.PrivateKey, nonce []byte, expiry time.Time) (*KeyPair, error) {
+	der, err := x509.MarshalPKIXPublicKey(private.PublicKey())
+	if err != nil {
+		return nil, fmt.Errorf("error encoding public key: %v", err)
+	}
+
+	return &KeyPair{
+		private: private,
+		nonce:   nonce,
+		Material: KeyMaterial{
+			CryptoAlg: CryptoAlg,
+			Curve:     Curve,
+			DHPublicKey: DHPublicKey{
+				Expiry:   expiry.UTC().Format(expiryLayout),
+				KeyValue: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			},
+			Nonce: base64.StdEncoding.EncodeToString(nonce),
+		},
+	}, nil
+}
+
+// Export returns the private key and nonce, base64 encoded, so a pending
+// session can outlive the process.
+func (k *KeyPair) Export() (privateKey, nonce string) {
+	return base64.StdEncoding.EncodeToString(k.private.Bytes()), k.Material.Nonce
+}
+
+// Encrypt seals data for the holder of remote, returning it base64 encoded
+// the way ReBIT carries encryptedFI.
+func (k *KeyPair) Encrypt(remote KeyMaterial, data []byte) (string, error) {
+	aead, iv, err := k.cipher(remote)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nil, iv, data, nil)), nil
+}
+
+// Decrypt opens an encryptedFI value sent by the holder of remote.
+func (k *KeyPair) Decrypt(remote KeyMaterial, encrypted string) ([]byte, error) {
+	aead, iv, err := k.cipher(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encrypted))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding encrypted data: %v", err)
+	}
+
+	data, err := aead.Open(nil, iv, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data: %v", err)
+	}
+	return data, nil
+}
+
+func (k *KeyPair) cipher(remote KeyMaterial) (cipher.AEAD, []byte, error) {
+	key, iv, err := k.sessionKey(remote)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, iv, nil
+}
+
+// sessionKey derives the AES key shared with remote and the IV: the XOR of
+// both nonces gives the HKDF salt (first 20 bytes) and the GCM IV (last 12).
+func (k *KeyPair) sessionKey(remote KeyMaterial) (key, iv []byte, err error) {
+	if remote.CryptoAlg != "" && !strings.EqualFold(remote.CryptoAlg, CryptoAlg) {
+		return nil, nil, fmt.Errorf("unsupported cryptoAlg %q", remote.CryptoAlg)
+	}
+	if remote.Curve != "" && !strings.EqualFold(remote.Curve, Curve) {
+		return nil, nil, fmt.Errorf("unsupported curve %q", remote.Curve)
+	}
+	if remote.DHPublicKey.Expiry != "" {
+		expiry, err := time.Parse(time.RFC3339, remote.DHPublicKey.Expiry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid key expiry %q", remote.DHPublicKey.Expiry)
+		}
+		if time.Now().After(expiry) {
+			return nil, nil, fmt.Errorf("remote public key expired at %s", remote.DHPublicKey.Expiry)
+		}
+	}
+
+	public, err := ParsePublicKey(remote.DHPublicKey.KeyValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := k.private.ECDH(public)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error computing shared secret: %v", err)
+	}
+
+	remoteNonce, err := base64.StdEncoding.DecodeString(remote.Nonce)
+	if err != nil || len(remoteNonce) != nonceSize {
+		return nil, nil, fmt.Errorf("invalid remote nonce")
+	}
+	xored := make([]byte, nonceSize)
+	for i := range xored {
+		xored[i] = k.nonce[i] ^ remoteNonce[i]
+	}
+	salt, iv := xored[:saltSize], xored[nonceSize-ivSize:]
+
+	key = make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, nil), key); err != nil {
+		return nil, nil, fmt.Errorf("error deriving session key: %v", err)
+	}
+	return key, iv, nil
+}
+
+// ParsePublicKey accepts a Curve25519 public key as a PEM or base64 X.509
+// SubjectPublicKeyInfo, or as the raw 32 byte key in base64.
+func ParsePublicKey(value string) (*ecdh.PublicKey, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		der = block.Bytes
+	} else {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding public key: %v", err)
+		}
+		if len(raw) == 32 {
+			return ecdh.X25519().NewPublicKey(raw)
+		}
+		der = raw
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key: %v", err)
+	}
+	public, ok := key.(*ecdh.PublicKey)
+	if !ok || public.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("public key is not a Curve25519 key")
+	}
+	return public, nil
+}
@@ -0,0 +1,153 @@
+package aacrypto
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The key pairs are Alice's and Bob's from RFC 7748 section 6.1. The
+// expected session key was derived independently with
+// `openssl kdf ... HKDF` from the RFC's shared secret and the XORed nonces.
+const (
+	alicePrivate = "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	aliceNonce   = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	bobPrivate   = "XasIfmJKikt54X+Lg4AO5m87sSkmGLb9HC+LJ/+I4Os="
+	bobPublic    = "3p7bfXt9wbTTW2HC7OQ1Nz+DQ8hbeGdNrfx+FG+IK08="
+	bobNonce     = "paWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaU="
+
+	sessionKeyHex = "b001832e520a6bcf180445f96c0d9e6544d3eb85a26647d5392f65a45e70e499"
+	ivHex         = "b1b0b3b2bdbcbfbeb9b8bbba"
+
+	plaintext  = `{"account":{"type":"deposit"}}`
+	ciphertext = "1WYkDd3p7S4jCtZj2tgvK42/Fi+sA39Hwe7yorVxjNBcyD9sEaE2e5qXmi/0mg=="
+)
+
+func importKeyPair(t *testing.T, privateKey, nonce string) *KeyPair {
+	t.Helper()
+	keys, err := ImportKeyPair(privateKey, nonce, time.Now().Add(KeyExpiry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func bobMaterial() KeyMaterial {
+	return KeyMaterial{
+		CryptoAlg:   CryptoAlg,
+		Curve:       Curve,
+		DHPublicKey: DHPublicKey{KeyValue: bobPublic},
+		Nonce:       bobNonce,
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	alice := importKeyPair(t, alicePrivate, aliceNonce)
+	bob := importKeyPair(t, bobPrivate, bobNonce)
+
+	for name, derive := range map[string]func() ([]byte, []byte, error){
+		"alice": func() ([]byte, []byte, error) { return alice.sessionKey(bobMaterial()) },
+		"bob":   func() ([]byte, []byte, error) { return bob.sessionKey(alice.Material) },
+	} {
+		key, iv, err := derive()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if hex.EncodeToString(key) != sessionKeyHex {
+			t.Errorf("%s: key = %x, want %s", name, key, sessionKeyHex)
+		}
+		if hex.EncodeToString(iv) != ivHex {
+			t.Errorf("%s: iv = %x, want %s", name, iv, ivHex)
+		}
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	alice := importKeyPair(t, alicePrivate, aliceNonce)
+
+	data, err := alice.Decrypt(bobMaterial(), ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != plaintext {
+		t.Errorf("Decrypt = %q, want %q", data, plaintext)
+	}
+
+	encrypted, err := alice.Encrypt(bobMaterial(), []byte(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted != ciphertext {
+		t.Errorf("Encrypt = %q, want %q", encrypted, ciphertext)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	fiu, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fip, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := fip.Encrypt(fiu.Material, []byte(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The FIU side survives a restart through Export and ImportKeyPair.
+	privateKey, nonce := fiu.Export()
+	restored := importKeyPair(t, privateKey, nonce)
+	data, err := restored.Decrypt(fip.Material, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != plaintext {
+		t.Errorf("Decrypt = %q, want %q", data, plaintext)
+	}
+
+	tampered := []byte(encrypted)
+	tampered[0] ^= 1
+	if _, err := fiu.Decrypt(fip.Material, string(tampered)); err == nil {
+		t.Error("tampered data decrypted")
+	}
+}
+
+func TestDecryptRejectsMaterial(t *testing.T) {
+	alice := importKeyPair(t, alicePrivate, aliceNonce)
+
+	for name, change := range map[string]func(m *KeyMaterial){
+		"other algorithm": func(m *KeyMaterial) { m.CryptoAlg = "RSA" },
+		"other curve":     func(m *KeyMaterial) { m.Curve = "P-256" },
+		"expired key":     func(m *KeyMaterial) { m.DHPublicKey.Expiry = "2020-01-01T00:00:00.000Z" },
+		"short nonce":     func(m *KeyMaterial) { m.Nonce = "AAEC" },
+		"bad public key":  func(m *KeyMaterial) { m.DHPublicKey.KeyValue = "not a key" },
+	} {
+		material := bobMaterial()
+		change(&material)
+		if _, err := alice.Decrypt(material, ciphertext); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	bob := importKeyPair(t, bobPrivate, bobNonce)
+	pemKey := bob.Material.DHPublicKey.KeyValue
+	if !strings.HasPrefix(pemKey, "-----BEGIN PUBLIC KEY-----") {
+		t.Fatalf("public key is not PEM: %q", pemKey)
+	}
+
+	for name, value := range map[string]string{"PEM": pemKey, "raw": bobPublic} {
+		key, err := ParsePublicKey(value)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if hex.EncodeToString(key.Bytes()) != "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f" {
+			t.Errorf("%s: key = %x", name, key.Bytes())
+		}
+	}
+}
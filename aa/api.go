@@ -0,0 +1,95 @@
+package aa
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"valyx/aggregator/types"
+)
+
+// APIVersion is the ReBIT AA API version we speak.
+const APIVersion = "1.1.2"
+
+// TimestampLayout is the ISO 8601 layout ReBIT uses for timestamps.
+const TimestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+type ConsentRequest struct {
+	Ver           string              `json:"ver"`
+	Timestamp     string              `json:"timestamp"`
+	TxnID         string              `json:"txnid"`
+	ConsentDetail types.ConsentDetail `json:"ConsentDetail"`
+}
+
+type ConsentResponse struct {
+	Ver           string                `json:"ver"`
+	Timestamp     string                `json:"timestamp"`
+	TxnID         string                `json:"txnid"`
+	Customer      types.ConsentCustomer `json:"Customer"`
+	ConsentHandle string                `json:"ConsentHandle"`
+}
+
+// Consent handle states returned by GET /Consent/handle.
+const (
+	HandleReady   = "READY"
+	HandlePending = "PENDING"
+	HandleFailed  = "FAILED"
+)
+
+type ConsentHandleResponse struct {
+	Ver           string        `json:"ver"`
+	Timestamp     string        `json:"timestamp"`
+	TxnID         string        `json:"txnid"`
+	ConsentHandle string        `json:"ConsentHandle"`
+	ConsentStatus ConsentStatus `json:"ConsentStatus"`
+}
+
+type ConsentStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type ConsentArtefact struct {
+	Ver             string     `json:"ver"`
+	TxnID           string     `json:"txnid"`
+	ConsentID       string     `json:"consentId"`
+	Status          string     `json:"status"`
+	CreateTimestamp string     `json:"createTimestamp"`
+	SignedConsent   string     `json:"signedConsent"`
+	ConsentUse      ConsentUse `json:"ConsentUse"`
+}
+
+type ConsentUse struct {
+	LogURI          string `json:"logUri"`
+	Count           int    `json:"count"`
+	LastUseDateTime string `json:"lastUseDateTime"`
+}
+
+// ErrorResponse is the ReBIT error body.
+type ErrorResponse struct {
+	Ver       string `json:"ver"`
+	Timestamp string `json:"timestamp"`
+	TxnID     string `json:"txnid"`
+	ErrorCode string `json:"errorCode"`
+	ErrorMsg  string `json:"errorMsg"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("AA error %s: %s", e.ErrorCode, e.ErrorMsg)
+}
+
+// NewTxnID returns a random UUID v4 for the txnid of a ReBIT request.
+func NewTxnID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Timestamp formats t the way ReBIT expects.
+func Timestamp(t time.Time) string {
+	return t.UTC().Format(TimestampLayout)
+}
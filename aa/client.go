@@ -0,0 +1,90 @@
+package aa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the ReBIT APIs of an Account Aggregator on behalf of our FIU.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewClient(baseURL, apiKey string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: httpClient,
+	}
+}
+
+func (c *Client) CreateConsent(req ConsentRequest) (ConsentResponse, error) {
+	var resp ConsentResponse
+	err := c.do(http.MethodPost, "/Consent", req, &resp)
+	return resp, err
+}
+
+func (c *Client) GetConsentStatus(consentHandle string) (ConsentHandleResponse, error) {
+	var resp ConsentHandleResponse
+	err := c.do(http.MethodGet, "/Consent/handle/"+url.PathEscape(consentHandle), nil, &resp)
+	return resp, err
+}
+
+func (c *Client) FetchConsent(consentID string) (ConsentArtefact, error) {
+	var resp ConsentArtefact
+	err := c.do(http.MethodGet, "/Consent/"+url.PathEscape(consentID), nil, &resp)
+	return resp, err
+}
+
+func (c *Client) do(method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding %s request: %v", path, err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("client_api_key", c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling AA %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading AA %s response: %v", path, err)
+	}
+
+	if resp.StatusCode >= 300 {
+		aaErr := &ErrorResponse{}
+		if json.Unmarshal(respBody, aaErr) == nil && aaErr.ErrorCode != "" {
+			return aaErr
+		}
+		return fmt.Errorf("AA %s returned %s", path, resp.Status)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("error decoding AA %s response: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package aa
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"valyx/aggregator/types"
+	"valyx/aggregator/utils"
+)
+
+// purposes are the ReBIT purpose codes we can request consent for.
+var purposes = map[string]types.ConsentPurpose{
+	"101": {Code: "101", Text: "Wealth management service", Category: types.PurposeCategory{Type: "Personal Finance"}},
+	"102": {Code: "102", Text: "Customer spending patterns, budget or other reportings", Category: types.PurposeCategory{Type: "Personal Finance"}},
+	"103": {Code: "103", Text: "Aggregated statement", Category: types.PurposeCategory{Type: "Financial Reporting"}},
+	"104": {Code: "104", Text: "Explicit consent for monitoring of the accounts", Category: types.PurposeCategory{Type: "Account Query and Monitoring"}},
+	"105": {Code: "105", Text: "Explicit one-time consent for accessing data from the accounts", Category: types.PurposeCategory{Type: "Account Query and Monitoring"}},
+}
+
+var (
+	dataLifeUnits  = []string{"DAY", "MONTH", "YEAR", "INF"}
+	frequencyUnits = []string{"HOUR", "DAY", "MONTH", "YEAR"}
+)
+
+// ConsentParams is what our API accepts to start a consent request. Anything
+// left empty gets a sensible default in RequestConsent.
+type ConsentParams struct {
+	CustomerID    string              `json:"customerId"`
+	PurposeCode   string              `json:"purposeCode"`
+	FITypes       []string            `json:"fiTypes"`
+	ConsentTypes  []string            `json:"consentTypes"`
+	ConsentMode   string              `json:"consentMode"`
+	FetchType     string              `json:"fetchType"`
+	From          string              `json:"from"`
+	To            string              `json:"to"`
+	ConsentExpiry string              `json:"consentExpiry"`
+	DataLife      types.ConsentPeriod `json:"dataLife"`
+	Frequency     types.ConsentPeriod `json:"frequency"`
+	DataFilter    []types.DataFilter  `json:"dataFilter"`
+}
+
+// ConsentManager drives the FIU side of the consent flow: it raises consent
+// requests with the AA, follows the consent handle until the customer acts on
+// it and keeps the resulting artefact in the store.
+type ConsentManager struct {
+	client *Client
+	store  types.ConsentStore
+	fiuID  string
+
+	// verifier checks the AA's signature on consent artefacts. Unsigned
+	// artefacts, as the mock AA issues, are only accepted with allowUnsigned.
+	verifier      *utils.JWSVerifier
+	allowUnsigned bool
+}
+
+func NewConsentManager(client *Client, store types.ConsentStore, fiuID string, verifier *utils.JWSVerifier, allowUnsigned bool) *ConsentManager {
+	return &ConsentManager{client: client, store: store, fiuID: fiuID, verifier: verifier, allowUnsigned: allowUnsigned}
+}
+
+// InvalidParamsError is returned by RequestConsent when the request is
+// rejected before the AA is called.
+type InvalidParamsError struct {
+	Err error
+}
+
+func (e *InvalidParamsError) Error() string {
+	return e.Err.Error()
+}
+
+func (m *ConsentManager) RequestConsent(params ConsentParams) (types.Consent, error) {
+	detail, err := m.consentDetail(params, time.Now())
+	if err != nil {
+		return types.Consent{}, &InvalidParamsError{Err: err}
+	}
+
+	resp, err := m.client.CreateConsent(ConsentRequest{
+		Ver:           APIVersion,
+		Timestamp:     Timestamp(time.Now()),
+		TxnID:         NewTxnID(),
+		ConsentDetail: detail,
+	})
+	if err != nil {
+		return types.Consent{}, err
+	}
+
+	consent := types.Consent{
+		ConsentHandle: resp.ConsentHandle,
+		Status:        types.ConsentPending,
+		Detail:        detail,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if err := m.store.SaveConsent(consent); err != nil {
+		return types.Consent{}, err
+	}
+	return consent, nil
+}
+
+// RefreshConsent asks the AA where a consent stands. Once the handle is READY
+// the artefact is fetched and stored along with the approved accounts.
+func (m *ConsentManager) RefreshConsent(consentHandle string) (types.Consent, error) {
+	consent, err := m.store.GetConsent(consentHandle)
+	if err != nil {
+		return types.Consent{}, err
+	}
+
+	if consent.ConsentID == "" {
+		status, err := m.client.GetConsentStatus(consentHandle)
+		if err != nil {
+			return types.Consent{}, err
+		}
+
+		switch status.ConsentStatus.Status {
+		case HandlePending:
+			return consent, nil
+		case HandleFailed:
+			consent.Status = types.ConsentFailed
+			consent.UpdatedAt = time.Now()
+			return consent, m.store.SaveConsent(consent)
+		}
+		consent.ConsentID = status.ConsentStatus.ID
+	}
+
+	artefact, err := m.client.FetchConsent(consent.ConsentID)
+	if err != nil {
+		return types.Consent{}, err
+	}
+	if artefact.SignedConsent != "" {
+		detail, err := DecodeSignedConsent(artefact.SignedConsent, m.verifier, m.allowUnsigned)
+		if err != nil {
+			return types.Consent{}, err
+		}
+		consent.Detail = detail
+		consent.SignedConsent = artefact.SignedConsent
+	}
+	consent.Status = artefact.Status
+	consent.UpdatedAt = time.Now()
+
+	if err := m.store.SaveConsent(consent); err != nil {
+		return types.Consent{}, err
+	}
+	return consent, nil
+}
+
+func (m *ConsentManager) consentDetail(params ConsentParams, now time.Time) (types.ConsentDetail, error) {
+	if params.CustomerID == "" {
+		return types.ConsentDetail{}, fmt.Errorf("customerId is required")
+	}
+
+	purpose, ok := purposes[params.PurposeCode]
+	if !ok {
+		return types.ConsentDetail{}, fmt.Errorf("unknown purpose code %q", params.PurposeCode)
+	}
+	purpose.RefURI = fmt.Sprintf("https://api.rebit.org.in/aa/purpose/%s.xml", purpose.Code)
+
+	from, err := parseConsentTime(params.From)
+	if err != nil {
+		return types.ConsentDetail{}, fmt.Errorf("invalid from: %v", err)
+	}
+	to, err := parseConsentTime(params.To)
+	if err != nil {
+		return types.ConsentDetail{}, fmt.Errorf("invalid to: %v", err)
+	}
+	if !from.Before(to) {
+		return types.ConsentDetail{}, fmt.Errorf("from must be before to")
+	}
+
+	expiry := now.AddDate(1, 0, 0)
+	if params.ConsentExpiry != "" {
+		if expiry, err = parseConsentTime(params.ConsentExpiry); err != nil {
+			return types.ConsentDetail{}, fmt.Errorf("invalid consentExpiry: %v", err)
+		}
+	}
+	if !expiry.After(now) {
+		return types.ConsentDetail{}, fmt.Errorf("consentExpiry must be in the future")
+	}
+
+	detail := types.ConsentDetail{
+		ConsentStart:  Timestamp(now),
+		ConsentExpiry: Timestamp(expiry),
+		ConsentMode:   defaultString(params.ConsentMode, "STORE"),
+		FetchType:     defaultString(params.FetchType, "ONETIME"),
+		ConsentTypes:  params.ConsentTypes,
+		FITypes:       params.FITypes,
+		DataConsumer:  types.ConsentEntity{ID: m.fiuID, Type: "FIU"},
+		Customer:      types.ConsentCustomer{ID: params.CustomerID},
+		Purpose:       purpose,
+		FIDataRange:   types.DateRange{From: Timestamp(from), To: Timestamp(to)},
+		DataLife:      params.DataLife,
+		Frequency:     params.Frequency,
+		DataFilter:    params.DataFilter,
+	}
+	if len(detail.ConsentTypes) == 0 {
+		detail.ConsentTypes = []string{"PROFILE", "SUMMARY", "TRANSACTIONS"}
+	}
+	if len(detail.FITypes) == 0 {
+		detail.FITypes = []string{"DEPOSIT"}
+	}
+	if detail.DataLife.Unit == "" {
+		detail.DataLife = types.ConsentPeriod{Unit: "MONTH", Value: 1}
+	}
+	if detail.Frequency.Unit == "" {
+		detail.Frequency = types.ConsentPeriod{Unit: "DAY", Value: 1}
+	}
+
+	switch detail.ConsentMode {
+	case "VIEW", "STORE", "QUERY", "STREAM":
+	default:
+		return types.ConsentDetail{}, fmt.Errorf("invalid consentMode %q", detail.ConsentMode)
+	}
+	switch detail.FetchType {
+	case "ONETIME", "PERIODIC":
+	default:
+		return types.ConsentDetail{}, fmt.Errorf("invalid fetchType %q", detail.FetchType)
+	}
+	if !contains(dataLifeUnits, detail.DataLife.Unit) || detail.DataLife.Value < 0 {
+		return types.ConsentDetail{}, fmt.Errorf("invalid dataLife %+v", detail.DataLife)
+	}
+	if !contains(frequencyUnits, detail.Frequency.Unit) || detail.Frequency.Value < 1 {
+		return types.ConsentDetail{}, fmt.Errorf("invalid frequency %+v", detail.Frequency)
+	}
+	return detail, nil
+}
+
+// DecodeSignedConsent returns the ConsentDetail carried in the payload of a
+// signed consent artefact (a compact JWS). The signature is checked against
+// verifier. Without allowUnsigned, as against a real AA, an unsigned (alg
+// none) artefact is refused, and so is a signed one when there is no
+// verifier to check it with.
+func DecodeSignedConsent(signedConsent string, verifier *utils.JWSVerifier, allowUnsigned bool) (types.ConsentDetail, error) {
+	parts := strings.Split(signedConsent, ".")
+	if len(parts) != 3 {
+		return types.ConsentDetail{}, fmt.Errorf("signed consent is not a compact JWS")
+	}
+
+	alg, err := utils.JWSAlg(signedConsent)
+	if err != nil {
+		return types.ConsentDetail{}, err
+	}
+	if strings.EqualFold(alg, "none") && !allowUnsigned {
+		return types.ConsentDetail{}, fmt.Errorf("consent artefact is not signed")
+	}
+
+	if !strings.EqualFold(alg, "none") && verifier == nil && !allowUnsigned {
+		return types.ConsentDetail{}, fmt.Errorf("no AA key configured to verify signed consent")
+	}
+
+	var payload []byte
+	if verifier != nil && !strings.EqualFold(alg, "none") {
+		if payload, err = verifier.VerifyCompact(signedConsent); err != nil {
+			return types.ConsentDetail{}, fmt.Errorf("error verifying signed consent: %v", err)
+		}
+	} else if payload, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return types.ConsentDetail{}, fmt.Errorf("error decoding signed consent payload: %v", err)
+	}
+
+	var detail types.ConsentDetail
+	if err := json.Unmarshal(payload, &detail); err != nil {
+		return types.ConsentDetail{}, fmt.Errorf("error decoding signed consent detail: %v", err)
+	}
+	return detail, nil
+}
+
+// parseConsentTime accepts full timestamps as well as plain dates.
+func parseConsentTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package aa
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodeSignedConsentUnsigned(t *testing.T) {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"consentMode":"STORE","fetchType":"PERIODIC"}`)) + "."
+
+	if _, err := DecodeSignedConsent(unsigned, nil, false); err == nil {
+		t.Error("unsigned consent accepted outside mock mode")
+	}
+
+	detail, err := DecodeSignedConsent(unsigned, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.ConsentMode != "STORE" || detail.FetchType != "PERIODIC" {
+		t.Errorf("detail = %+v", detail)
+	}
+}
+
+func TestDecodeSignedConsentWithoutVerifier(t *testing.T) {
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"aa"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"consentMode":"STORE"}`)) + ".c2lnbmF0dXJl"
+
+	if _, err := DecodeSignedConsent(signed, nil, false); err == nil {
+		t.Error("signed consent accepted without a key to verify it")
+	}
+	if detail, err := DecodeSignedConsent(signed, nil, true); err != nil || detail.ConsentMode != "STORE" {
+		t.Errorf("mock mode: %+v, %v", detail, err)
+	}
+}
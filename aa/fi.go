@@ -0,0 +1,227 @@
+package aa
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"valyx/aggregator/aa/aacrypto"
+	"valyx/aggregator/types"
+)
+
+type FIRequest struct {
+	Ver         string               `json:"ver"`
+	Timestamp   string               `json:"timestamp"`
+	TxnID       string               `json:"txnid"`
+	FIDataRange types.DateRange      `json:"FIDataRange"`
+	Consent     FIConsent            `json:"Consent"`
+	KeyMaterial aacrypto.KeyMaterial `json:"KeyMaterial"`
+}
+
+type FIConsent struct {
+	ID               string `json:"id"`
+	DigitalSignature string `json:"digitalSignature"`
+}
+
+type FIRequestResponse struct {
+	Ver       string `json:"ver"`
+	Timestamp string `json:"timestamp"`
+	TxnID     string `json:"txnid"`
+	ConsentID string `json:"consentId"`
+	SessionID string `json:"sessionId"`
+}
+
+type FIFetchResponse struct {
+	Ver       string   `json:"ver"`
+	Timestamp string   `json:"timestamp"`
+	TxnID     string   `json:"txnid"`
+	FI        []FIData `json:"FI"`
+}
+
+// FIData is what one FIP returned for the session, encrypted with the key
+// material it sent alongside.
+type FIData struct {
+	FIPID       string               `json:"fipID"`
+	Data        []EncryptedFI        `json:"data"`
+	KeyMaterial aacrypto.KeyMaterial `json:"KeyMaterial"`
+}
+
+type EncryptedFI struct {
+	LinkRefNumber   string `json:"linkRefNumber"`
+	MaskedAccNumber string `json:"maskedAccNumber"`
+	EncryptedFI     string `json:"encryptedFI"`
+}
+
+// FIPayload is the decrypted FI data of one account, ready for the importer.
+type FIPayload struct {
+	ConsentID       string
+	FIPID           string
+	LinkRefNumber   string
+	MaskedAccNumber string
+	Data            []byte
+}
+
+func (c *Client) RequestFI(req FIRequest) (FIRequestResponse, error) {
+	var resp FIRequestResponse
+	err := c.do(http.MethodPost, "/FI/request", req, &resp)
+	return resp, err
+}
+
+func (c *Client) FetchFI(sessionID string) (FIFetchResponse, error) {
+	var resp FIFetchResponse
+	err := c.do(http.MethodGet, "/FI/fetch/"+url.PathEscape(sessionID), nil, &resp)
+	return resp, err
+}
+
+// DataFetcher requests FI data under an active consent and decrypts it once
+// the AA has it ready. The ephemeral key pair of every open session is held
+// until that session is fetched.
+type DataFetcher struct {
+	client *Client
+
+	mu       sync.Mutex
+	sessions map[string]*fiSession
+}
+
+type fiSession struct {
+	consentID string
+	keys      *aacrypto.KeyPair
+}
+
+func NewDataFetcher(client *Client) *DataFetcher {
+	return &DataFetcher{client: client, sessions: make(map[string]*fiSession)}
+}
+
+// RequestData opens an FI data session for the consent covering from..to and
+// returns its session ID.
+func (f *DataFetcher) RequestData(consent types.Consent, from, to time.Time) (string, error) {
+	if consent.Status != types.ConsentActive {
+		return "", fmt.Errorf("consent %s is %s, not %s", consent.ConsentHandle, consent.Status, types.ConsentActive)
+	}
+
+	keys, err := aacrypto.GenerateKeyPair()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := f.client.RequestFI(FIRequest{
+		Ver:         APIVersion,
+		Timestamp:   Timestamp(time.Now()),
+		TxnID:       NewTxnID(),
+		FIDataRange: types.DateRange{From: Timestamp(from), To: Timestamp(to)},
+		Consent: FIConsent{
+			ID:               consent.ConsentID,
+			DigitalSignature: consentSignature(consent.SignedConsent),
+		},
+		KeyMaterial: keys.Material,
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.SessionID == "" {
+		return "", fmt.Errorf("AA returned no sessionId for consent %s", consent.ConsentID)
+	}
+
+	f.mu.Lock()
+	f.sessions[resp.SessionID] = &fiSession{consentID: consent.ConsentID, keys: keys}
+	f.mu.Unlock()
+	return resp.SessionID, nil
+}
+
+// SessionKey exports the key pair of an open session, so it can be stored
+// and the session resumed with ResumeSession after a restart.
+func (f *DataFetcher) SessionKey(sessionID string) (privateKey, nonce string, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session, ok := f.sessions[sessionID]
+	if !ok {
+		return "", "", false
+	}
+	privateKey, nonce = session.keys.Export()
+	return privateKey, nonce, true
+}
+
+// ResumeSession reopens a session requested by an earlier process from its
+// exported key pair.
+func (f *DataFetcher) ResumeSession(sessionID, consentID, privateKey, nonce string, expiry time.Time) error {
+	keys, err := aacrypto.ImportKeyPair(privateKey, nonce, expiry)
+	if err != nil {
+		return fmt.Errorf("error restoring key of FI session %s: %v", sessionID, err)
+	}
+
+	f.mu.Lock()
+	f.sessions[sessionID] = &fiSession{consentID: consentID, keys: keys}
+	f.mu.Unlock()
+	return nil
+}
+
+// FetchData pulls the FI data of a session and decrypts every account in it.
+// The session is claimed while the fetch runs, so repeated notifications for
+// it do not fetch twice, and dropped once the data has been read.
+func (f *DataFetcher) FetchData(sessionID string) ([]FIPayload, error) {
+	f.mu.Lock()
+	session, ok := f.sessions[sessionID]
+	delete(f.sessions, sessionID)
+	f.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown or already fetched FI session %s", sessionID)
+	}
+
+	payloads, err := f.fetch(sessionID, session)
+	if err != nil {
+		f.mu.Lock()
+		f.sessions[sessionID] = session
+		f.mu.Unlock()
+		return nil, err
+	}
+	return payloads, nil
+}
+
+func (f *DataFetcher) fetch(sessionID string, session *fiSession) ([]FIPayload, error) {
+	resp, err := f.client.FetchFI(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads, err := DecryptFI(session.keys, resp)
+	if err != nil {
+		return nil, err
+	}
+	for i := range payloads {
+		payloads[i].ConsentID = session.consentID
+	}
+	return payloads, nil
+}
+
+// DecryptFI decrypts an /FI/fetch response with our key pair for the session.
+func DecryptFI(keys *aacrypto.KeyPair, resp FIFetchResponse) ([]FIPayload, error) {
+	var payloads []FIPayload
+	for _, fi := range resp.FI {
+		for _, account := range fi.Data {
+			data, err := keys.Decrypt(fi.KeyMaterial, account.EncryptedFI)
+			if err != nil {
+				return nil, fmt.Errorf("error decrypting FI data of %s from %s: %v", account.MaskedAccNumber, fi.FIPID, err)
+			}
+			payloads = append(payloads, FIPayload{
+				FIPID:           fi.FIPID,
+				LinkRefNumber:   account.LinkRefNumber,
+				MaskedAccNumber: account.MaskedAccNumber,
+				Data:            data,
+			})
+		}
+	}
+	return payloads, nil
+}
+
+// consentSignature is the signature segment of the signed consent artefact,
+// which /FI/request carries as the consent's digitalSignature.
+func consentSignature(signedConsent string) string {
+	parts := strings.Split(signedConsent, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[2]
+}
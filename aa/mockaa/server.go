@@ -0,0 +1,255 @@
+// Package mockaa is a small in-memory Account Aggregator speaking the ReBIT
+// consent APIs, so the FIU flow can be exercised without a live AA.
+package mockaa
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"valyx/aggregator/aa"
+	"valyx/aggregator/types"
+)
+
+type consentRecord struct {
+	handle        string
+	id            string
+	status        string
+	consentStatus string
+	detail        types.ConsentDetail
+	created       time.Time
+	fetchCount    int
+}
+
+// Server keeps consents in memory. With autoApprove every consent request is
+// approved for all linked accounts as soon as it is created; otherwise it
+// waits for POST /mock/Consent/{handle}/approve.
+type Server struct {
+	mu          sync.Mutex
+	byHandle    map[string]*consentRecord
+	byID        map[string]*consentRecord
+	accounts    []types.ConsentAccount
+	autoApprove bool
+}
+
+// DefaultAccounts links the accounts loaded from ./dummyData, so consents
+// approved by the mock cover the data we already have.
+func DefaultAccounts() []types.ConsentAccount {
+	return []types.ConsentAccount{
+		{FIType: "DEPOSIT", FIPID: "HDFC-FIP", AccType: "SAVINGS", LinkRefNumber: "hdfc", MaskedAccNumber: "XXXXXXXX4454"},
+		{FIType: "DEPOSIT", FIPID: "ICICI-FIP", AccType: "SAVINGS", LinkRefNumber: "icici", MaskedAccNumber: "XXXXXXXX4482"},
+		{FIType: "DEPOSIT", FIPID: "AXIS-FIP", AccType: "CURRENT", LinkRefNumber: "axis", MaskedAccNumber: "XXXXXXXX8832"},
+	}
+}
+
+func NewServer(accounts []types.ConsentAccount, autoApprove bool) *Server {
+	return &Server{
+		byHandle:    make(map[string]*consentRecord),
+		byID:        make(map[string]*consentRecord),
+		accounts:    accounts,
+		autoApprove: autoApprove,
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Consent", s.createConsent)
+	mux.HandleFunc("/Consent/", s.getConsent)
+	mux.HandleFunc("/mock/Consent/", s.controlConsent)
+	return mux
+}
+
+func (s *Server) createConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "", "InvalidRequest", "method not allowed")
+		return
+	}
+
+	var req aa.ConsentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "", "InvalidRequest", "malformed consent request")
+		return
+	}
+	detail := req.ConsentDetail
+	switch {
+	case req.TxnID == "":
+		writeError(w, http.StatusBadRequest, req.TxnID, "InvalidRequest", "txnid is required")
+		return
+	case detail.Customer.ID == "" || detail.DataConsumer.ID == "":
+		writeError(w, http.StatusBadRequest, req.TxnID, "InvalidConsentDetail", "Customer and DataConsumer are required")
+		return
+	case detail.FIDataRange.From == "" || detail.FIDataRange.To == "":
+		writeError(w, http.StatusBadRequest, req.TxnID, "InvalidConsentDetail", "FIDataRange is required")
+		return
+	}
+
+	record := &consentRecord{
+		handle:  aa.NewTxnID(),
+		status:  aa.HandlePending,
+		detail:  detail,
+		created: time.Now(),
+	}
+
+	s.mu.Lock()
+	s.byHandle[record.handle] = record
+	if s.autoApprove {
+		s.approve(record)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, aa.ConsentResponse{
+		Ver:           aa.APIVersion,
+		Timestamp:     aa.Timestamp(time.Now()),
+		TxnID:         req.TxnID,
+		Customer:      detail.Customer,
+		ConsentHandle: record.handle,
+	})
+}
+
+func (s *Server) getConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "", "InvalidRequest", "method not allowed")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/Consent/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if handle := strings.TrimPrefix(path, "handle/"); handle != path {
+		record, ok := s.byHandle[handle]
+		if !ok {
+			writeError(w, http.StatusNotFound, "", "InvalidConsentHandle", "unknown consent handle")
+			return
+		}
+		writeJSON(w, http.StatusOK, aa.ConsentHandleResponse{
+			Ver:           aa.APIVersion,
+			Timestamp:     aa.Timestamp(time.Now()),
+			TxnID:         aa.NewTxnID(),
+			ConsentHandle: record.handle,
+			ConsentStatus: aa.ConsentStatus{ID: record.id, Status: record.status},
+		})
+		return
+	}
+
+	record, ok := s.byID[path]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "InvalidConsentId", "unknown consent id")
+		return
+	}
+	record.fetchCount++
+	writeJSON(w, http.StatusOK, aa.ConsentArtefact{
+		Ver:             aa.APIVersion,
+		TxnID:           aa.NewTxnID(),
+		ConsentID:       record.id,
+		Status:          record.consentStatus,
+		CreateTimestamp: aa.Timestamp(record.created),
+		SignedConsent:   signConsent(record.detail),
+		ConsentUse: aa.ConsentUse{
+			Count:           record.fetchCount,
+			LastUseDateTime: aa.Timestamp(time.Now()),
+		},
+	})
+}
+
+// controlConsent lets a test play the customer: POST
+// /mock/Consent/{handle}/{approve|reject|pause|resume|revoke|expire}.
+func (s *Server) controlConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "", "InvalidRequest", "method not allowed")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/mock/Consent/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "", "InvalidRequest", "expected /mock/Consent/{handle}/{action}")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.byHandle[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "InvalidConsentHandle", "unknown consent handle")
+		return
+	}
+
+	switch parts[1] {
+	case "approve":
+		s.approve(record)
+	case "reject":
+		record.status = aa.HandleFailed
+	case "pause", "resume", "revoke", "expire":
+		if record.id == "" {
+			writeError(w, http.StatusConflict, "", "InvalidConsentStatus", "consent is not approved")
+			return
+		}
+		record.consentStatus = map[string]string{
+			"pause":  types.ConsentPaused,
+			"resume": types.ConsentActive,
+			"revoke": types.ConsentRevoked,
+			"expire": types.ConsentExpired,
+		}[parts[1]]
+	default:
+		writeError(w, http.StatusNotFound, "", "InvalidRequest", "unknown action")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ConsentAccounts returns the accounts an active consent was approved for, so
+// a simulated FIP behind this AA serves only what the customer shared.
+func (s *Server) ConsentAccounts(consentID string) ([]types.ConsentAccount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.byID[consentID]
+	if !ok {
+		return nil, fmt.Errorf("unknown consent id %s", consentID)
+	}
+	if record.consentStatus != types.ConsentActive {
+		return nil, fmt.Errorf("consent %s is %s", consentID, record.consentStatus)
+	}
+	return record.detail.Accounts, nil
+}
+
+// approve must be called with s.mu held.
+func (s *Server) approve(record *consentRecord) {
+	if record.id != "" {
+		return
+	}
+	record.id = aa.NewTxnID()
+	record.status = aa.HandleReady
+	record.detail.Accounts = s.accounts
+	record.consentStatus = types.ConsentActive
+	s.byID[record.id] = record
+}
+
+// signConsent wraps the detail in an unsigned compact JWS.
+func signConsent(detail types.ConsentDetail) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	payload, _ := json.Marshal(detail)
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, txnID, code, msg string) {
+	writeJSON(w, status, aa.ErrorResponse{
+		Ver:       aa.APIVersion,
+		Timestamp: aa.Timestamp(time.Now()),
+		TxnID:     txnID,
+		ErrorCode: code,
+		ErrorMsg:  msg,
+	})
+}
@@ -0,0 +1,37 @@
+package mockfip
+
+// The subset of the ReBIT DEPOSIT FI schema the simulator fills in.
+
+type depositAccount struct {
+	Type            string              `json:"type"`
+	MaskedAccNumber string              `json:"maskedAccNumber"`
+	LinkedAccRef    string              `json:"linkedAccRef"`
+	Version         string              `json:"version"`
+	Summary         depositSummary      `json:"Summary"`
+	Transactions    depositTransactions `json:"Transactions"`
+}
+
+type depositSummary struct {
+	CurrentBalance  string `json:"currentBalance,omitempty"`
+	Currency        string `json:"currency"`
+	BalanceDateTime string `json:"balanceDateTime"`
+	Type            string `json:"type"`
+	Status          string `json:"status"`
+}
+
+type depositTransactions struct {
+	StartDate   string               `json:"startDate"`
+	EndDate     string               `json:"endDate"`
+	Transaction []depositTransaction `json:"Transaction"`
+}
+
+type depositTransaction struct {
+	TxnID                string `json:"txnId"`
+	Type                 string `json:"type"`
+	Mode                 string `json:"mode"`
+	Amount               string `json:"amount"`
+	CurrentBalance       string `json:"currentBalance,omitempty"`
+	TransactionTimestamp string `json:"transactionTimestamp"`
+	ValueDate            string `json:"valueDate"`
+	Narration            string `json:"narration"`
+}
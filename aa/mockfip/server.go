@@ -0,0 +1,375 @@
+// Package mockfip simulates an FIP behind an AA: it answers ReBIT /FI/request
+// and /FI/fetch with the transactions we already hold, encrypted for the
+// requester, and can be made slow or flaky to exercise our AA clients.
+package mockfip
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"valyx/aggregator/aa"
+	"valyx/aggregator/aa/aacrypto"
+	"valyx/aggregator/types"
+	"valyx/aggregator/utils"
+)
+
+type Options struct {
+	// FIPID is reported for accounts the consent does not name an FIP for.
+	FIPID string
+	// Latency delays every response.
+	Latency time.Duration
+	// ReadyAfter is how long a session takes before its data can be fetched.
+	ReadyAfter time.Duration
+	// FailureRate is the share of sessions, between 0 and 1, that fail.
+	FailureRate float64
+	// NotifyURL is the FIU base URL that gets /FI/Notification once a
+	// session is ready or has failed. Empty means the FIU has to poll.
+	NotifyURL string
+	// Signer signs the notifications the way the AA would, so the FIU can
+	// check them against the JWKS it has for the AA.
+	Signer *utils.JWSSigner
+	// ConsentAccounts resolves the accounts a consent covers. When nil every
+	// account in the database is served.
+	ConsentAccounts func(consentID string) ([]types.ConsentAccount, error)
+}
+
+// sessionTTL is how long a session that is never fetched is kept, matching
+// the lifetime of the requester's key.
+const sessionTTL = aacrypto.KeyExpiry
+
+type session struct {
+	consentID string
+	remote    aacrypto.KeyMaterial
+	from, to  time.Time
+	accounts  []types.ConsentAccount
+	readyAt   time.Time
+	failed    bool
+}
+
+type Server struct {
+	db      types.DB
+	options Options
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func NewServer(db types.DB, options Options) *Server {
+	if options.FIPID == "" {
+		options.FIPID = "MOCK-FIP"
+	}
+	return &Server{db: db, options: options, sessions: make(map[string]*session)}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/FI/request", s.requestFI)
+	mux.HandleFunc("/FI/fetch/", s.fetchFI)
+	return mux
+}
+
+func (s *Server) requestFI(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(s.options.Latency)
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "", "InvalidRequest", "method not allowed")
+		return
+	}
+
+	var req aa.FIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "", "InvalidRequest", "malformed FI request")
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, req.FIDataRange.From)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, req.TxnID, "InvalidDateRange", "invalid FIDataRange from")
+		return
+	}
+	to, err := time.Parse(time.RFC3339, req.FIDataRange.To)
+	if err != nil || !from.Before(to) {
+		writeError(w, http.StatusBadRequest, req.TxnID, "InvalidDateRange", "invalid FIDataRange to")
+		return
+	}
+	if _, err := aacrypto.ParsePublicKey(req.KeyMaterial.DHPublicKey.KeyValue); err != nil {
+		writeError(w, http.StatusBadRequest, req.TxnID, "InvalidKeyMaterial", err.Error())
+		return
+	}
+
+	accounts, err := s.accounts(req.Consent.ID)
+	if err != nil {
+		writeError(w, http.StatusForbidden, req.TxnID, "InvalidConsentId", err.Error())
+		return
+	}
+
+	sessionID := aa.NewTxnID()
+	sess := &session{
+		consentID: req.Consent.ID,
+		remote:    req.KeyMaterial,
+		from:      from,
+		to:        to,
+		accounts:  accounts,
+		readyAt:   time.Now().Add(s.options.ReadyAfter),
+		failed:    rand.Float64() < s.options.FailureRate,
+	}
+	s.mu.Lock()
+	s.pruneSessions(time.Now())
+	s.sessions[sessionID] = sess
+	s.mu.Unlock()
+
+	if s.options.NotifyURL != "" {
+		time.AfterFunc(s.options.ReadyAfter, func() { s.notify(sessionID, sess) })
+	}
+
+	writeJSON(w, http.StatusOK, aa.FIRequestResponse{
+		Ver:       aa.APIVersion,
+		Timestamp: aa.Timestamp(time.Now()),
+		TxnID:     req.TxnID,
+		ConsentID: req.Consent.ID,
+		SessionID: sessionID,
+	})
+}
+
+func (s *Server) fetchFI(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(s.options.Latency)
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "", "InvalidRequest", "method not allowed")
+		return
+	}
+
+	sessionID := strings.TrimPrefix(r.URL.Path, "/FI/fetch/")
+	s.mu.Lock()
+	sess, ok := s.sessions[sessionID]
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "", "InvalidSessionId", "unknown session id")
+		return
+	case sess.failed:
+		writeError(w, http.StatusInternalServerError, "", "InternalError", "FIP failed to deliver data")
+		return
+	case time.Now().Before(sess.readyAt):
+		writeError(w, http.StatusConflict, "", "DataNotReady", "FI data is not ready yet")
+		return
+	}
+
+	fipKeys, err := aacrypto.GenerateKeyPair()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "", "InternalError", err.Error())
+		return
+	}
+
+	byFIP := make(map[string]*aa.FIData)
+	var fipIDs []string
+	for _, account := range sess.accounts {
+		data, err := s.accountData(account, sess.from, sess.to)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "", "InternalError", err.Error())
+			return
+		}
+		encrypted, err := fipKeys.Encrypt(sess.remote, data)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "", "InternalError", err.Error())
+			return
+		}
+
+		fipID := account.FIPID
+		if fipID == "" {
+			fipID = s.options.FIPID
+		}
+		if byFIP[fipID] == nil {
+			byFIP[fipID] = &aa.FIData{FIPID: fipID, KeyMaterial: fipKeys.Material}
+			fipIDs = append(fipIDs, fipID)
+		}
+		byFIP[fipID].Data = append(byFIP[fipID].Data, aa.EncryptedFI{
+			LinkRefNumber:   account.LinkRefNumber,
+			MaskedAccNumber: account.MaskedAccNumber,
+			EncryptedFI:     encrypted,
+		})
+	}
+
+	resp := aa.FIFetchResponse{
+		Ver:       aa.APIVersion,
+		Timestamp: aa.Timestamp(time.Now()),
+		TxnID:     aa.NewTxnID(),
+	}
+	for _, fipID := range fipIDs {
+		resp.FI = append(resp.FI, *byFIP[fipID])
+	}
+
+	s.mu.Lock()
+	delete(s.sessions, sessionID)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// pruneSessions drops the sessions nobody fetched within sessionTTL. It must
+// be called with s.mu held.
+func (s *Server) pruneSessions(now time.Time) {
+	for id, sess := range s.sessions {
+		if now.Sub(sess.readyAt) > sessionTTL {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// accounts returns what the consent covers, or every account we hold when
+// no consent lookup is configured.
+func (s *Server) accounts(consentID string) ([]types.ConsentAccount, error) {
+	if s.options.ConsentAccounts != nil {
+		return s.options.ConsentAccounts(consentID)
+	}
+
+	ids, err := s.db.GetUniqueBankAccounts()
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]types.ConsentAccount, 0, len(ids))
+	for _, id := range ids {
+		accounts = append(accounts, types.ConsentAccount{
+			FIType:          "DEPOSIT",
+			FIPID:           s.options.FIPID,
+			AccType:         "SAVINGS",
+			LinkRefNumber:   id,
+			MaskedAccNumber: mask(id),
+		})
+	}
+	return accounts, nil
+}
+
+// accountData renders the account's transactions in from..to as ReBIT
+// DEPOSIT FI data in JSON.
+func (s *Server) accountData(account types.ConsentAccount, from, to time.Time) ([]byte, error) {
+	transactions, err := s.db.QueryTransactions("", []string{account.LinkRefNumber}, from, to)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Date < transactions[j].Date
+	})
+
+	fi := depositAccount{
+		Type:            "deposit",
+		MaskedAccNumber: account.MaskedAccNumber,
+		LinkedAccRef:    account.LinkRefNumber,
+		Version:         "1.1",
+		Summary: depositSummary{
+			Currency:        "INR",
+			BalanceDateTime: aa.Timestamp(time.Now()),
+			Type:            account.AccType,
+			Status:          "ACTIVE",
+		},
+		Transactions: depositTransactions{
+			StartDate: from.Format("2006-01-02"),
+			EndDate:   to.Format("2006-01-02"),
+		},
+	}
+
+	for _, t := range transactions {
+		date := t.Date
+		if len(date) > 10 {
+			date = date[:10]
+		}
+		txn := depositTransaction{
+			TxnID:                txnID(account.LinkRefNumber, t),
+			Mode:                 "OTHERS",
+			TransactionTimestamp: date + "T00:00:00.000+05:30",
+			ValueDate:            date,
+			Narration:            t.Description,
+		}
+		if t.Debit.Valid {
+			txn.Type = "DEBIT"
+			txn.Amount = strconv.FormatFloat(t.Debit.Float64, 'f', 2, 64)
+		} else {
+			txn.Type = "CREDIT"
+			txn.Amount = strconv.FormatFloat(t.Credit.Float64, 'f', 2, 64)
+		}
+		if t.Balance.Valid {
+			txn.CurrentBalance = strconv.FormatFloat(t.Balance.Float64, 'f', 2, 64)
+			fi.Summary.CurrentBalance = txn.CurrentBalance
+		}
+		fi.Transactions.Transaction = append(fi.Transactions.Transaction, txn)
+	}
+
+	return json.Marshal(struct {
+		Account depositAccount `json:"Account"`
+	}{fi})
+}
+
+// notify tells the FIU the session is ready, or that it failed.
+func (s *Server) notify(sessionID string, sess *session) {
+	status := aa.FIStatusNotification{SessionID: sessionID, SessionStatus: aa.SessionCompleted}
+	accountStatus := aa.FIReady
+	if sess.failed {
+		status.SessionStatus = aa.SessionFailed
+		accountStatus = aa.FIDenied
+	}
+	for _, account := range sess.accounts {
+		status.FIStatusResponse = append(status.FIStatusResponse, aa.FIStatusResponse{
+			FIPID:    account.FIPID,
+			Accounts: []aa.FIAccountStatus{{LinkRefNumber: account.LinkRefNumber, FIStatus: accountStatus}},
+		})
+	}
+
+	body, err := json.Marshal(aa.FINotification{
+		Ver:                  aa.APIVersion,
+		Timestamp:            aa.Timestamp(time.Now()),
+		TxnID:                aa.NewTxnID(),
+		Notifier:             aa.Notifier{Type: "AA", ID: "MOCK-AA"},
+		FIStatusNotification: status,
+	})
+	if err != nil {
+		log.Printf("FI session %s: error encoding notification: %v", sessionID, err)
+		return
+	}
+
+	client := &http.Client{Transport: &utils.SigningTransport{Signer: s.options.Signer}, Timeout: 30 * time.Second}
+	resp, err := client.Post(strings.TrimSuffix(s.options.NotifyURL, "/")+"/FI/Notification", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		log.Printf("FI session %s: error notifying FIU: %v", sessionID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("FI session %s: FIU rejected notification: %s", sessionID, resp.Status)
+	}
+}
+
+// txnID is stable across fetches so repeated syncs report the same IDs.
+func txnID(accountId string, t types.Transaction) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%v|%v|%v", accountId, t.Date, t.Description, t.Debit, t.Credit, t.Balance)))
+	return fmt.Sprintf("%x", sum[:10])
+}
+
+func mask(accountId string) string {
+	if len(accountId) <= 4 {
+		return accountId
+	}
+	return strings.Repeat("X", len(accountId)-4) + accountId[len(accountId)-4:]
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, txnID, code, msg string) {
+	writeJSON(w, status, aa.ErrorResponse{
+		Ver:       aa.APIVersion,
+		Timestamp: aa.Timestamp(time.Now()),
+		TxnID:     txnID,
+		ErrorCode: code,
+		ErrorMsg:  msg,
+	})
+}
@@ -0,0 +1,135 @@
+package aa
+
+import (
+	"fmt"
+	"time"
+
+	"valyx/aggregator/types"
+)
+
+type Notifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type ConsentNotification struct {
+	Ver                       string                    `json:"ver"`
+	Timestamp                 string                    `json:"timestamp"`
+	TxnID                     string                    `json:"txnid"`
+	Notifier                  Notifier                  `json:"Notifier"`
+	ConsentStatusNotification ConsentStatusNotification `json:"ConsentStatusNotification"`
+}
+
+type ConsentStatusNotification struct {
+	ConsentID     string `json:"consentId"`
+	ConsentHandle string `json:"consentHandle"`
+	ConsentStatus string `json:"consentStatus"`
+}
+
+// FI session and account states carried by an FI notification.
+const (
+	SessionActive    = "ACTIVE"
+	SessionCompleted = "COMPLETED"
+	SessionExpired   = "EXPIRED"
+	SessionFailed    = "FAILED"
+
+	FIReady     = "READY"
+	FIDenied    = "DENIED"
+	FIPending   = "PENDING"
+	FIDelivered = "DELIVERED"
+	FITimeout   = "TIMEOUT"
+)
+
+type FINotification struct {
+	Ver                  string               `json:"ver"`
+	Timestamp            string               `json:"timestamp"`
+	TxnID                string               `json:"txnid"`
+	Notifier             Notifier             `json:"Notifier"`
+	FIStatusNotification FIStatusNotification `json:"FIStatusNotification"`
+}
+
+type FIStatusNotification struct {
+	SessionID        string             `json:"sessionId"`
+	SessionStatus    string             `json:"sessionStatus"`
+	FIStatusResponse []FIStatusResponse `json:"FIStatusResponse"`
+}
+
+type FIStatusResponse struct {
+	FIPID    string            `json:"fipID"`
+	Accounts []FIAccountStatus `json:"Accounts"`
+}
+
+type FIAccountStatus struct {
+	LinkRefNumber string `json:"linkRefNumber"`
+	FIStatus      string `json:"FIStatus"`
+	Description   string `json:"description"`
+}
+
+// DataReady reports whether the session has data we can fetch: either the
+// whole session completed or at least one account is READY.
+func (n FIStatusNotification) DataReady() bool {
+	if n.SessionStatus == SessionCompleted {
+		return true
+	}
+	for _, fip := range n.FIStatusResponse {
+		for _, account := range fip.Accounts {
+			if account.FIStatus == FIReady {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NotificationResponse acknowledges a notification from the AA.
+type NotificationResponse struct {
+	Ver       string `json:"ver"`
+	Timestamp string `json:"timestamp"`
+	TxnID     string `json:"txnid"`
+	Response  string `json:"response"`
+}
+
+func NewNotificationResponse(txnID string) NotificationResponse {
+	return NotificationResponse{
+		Ver:       APIVersion,
+		Timestamp: Timestamp(time.Now()),
+		TxnID:     txnID,
+		Response:  "OK",
+	}
+}
+
+// ApplyNotification records a consent status pushed by the AA. A consent that
+// turns ACTIVE before we hold its artefact is refreshed so the signed consent
+// and approved accounts are stored with it.
+func (m *ConsentManager) ApplyNotification(n ConsentStatusNotification) (types.Consent, error) {
+	switch n.ConsentStatus {
+	case types.ConsentActive, types.ConsentPaused, types.ConsentRevoked, types.ConsentExpired, types.ConsentRejected:
+	default:
+		return types.Consent{}, fmt.Errorf("unknown consent status %q", n.ConsentStatus)
+	}
+
+	consent, err := m.store.GetConsentByID(n.ConsentID)
+	if err != nil && n.ConsentHandle != "" {
+		consent, err = m.store.GetConsent(n.ConsentHandle)
+	}
+	if err != nil {
+		return types.Consent{}, err
+	}
+
+	if consent.ConsentID == "" {
+		consent.ConsentID = n.ConsentID
+	}
+	if n.ConsentStatus == types.ConsentActive && consent.SignedConsent == "" && consent.ConsentID != "" {
+		if err := m.store.SaveConsent(consent); err != nil {
+			return types.Consent{}, err
+		}
+		return m.RefreshConsent(consent.ConsentHandle)
+	}
+
+	consent.Status = n.ConsentStatus
+	consent.UpdatedAt = time.Now()
+	if err := m.store.SaveConsent(consent); err != nil {
+		return types.Consent{}, err
+	}
+	return consent, nil
+}
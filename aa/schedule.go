@@ -0,0 +1,88 @@
+package aa
+
+import (
+	"fmt"
+	"time"
+
+	"valyx/aggregator/types"
+)
+
+// frequencyPeriods approximate the frequency units with fixed durations, so a
+// consent allowing 1/MONTH is fetched at most every 30 days.
+var frequencyPeriods = map[string]time.Duration{
+	"HOUR":  time.Hour,
+	"DAY":   24 * time.Hour,
+	"MONTH": 30 * 24 * time.Hour,
+	"YEAR":  365 * 24 * time.Hour,
+}
+
+// A ONETIME consent allows a single fetch, so when it fails it is retried
+// with a doubling delay, up to MaxOneTimeAttempts requests in all.
+const (
+	MaxOneTimeAttempts = 5
+	oneTimeRetryDelay  = 15 * time.Minute
+)
+
+// FetchInterval is the shortest gap between two fetches that keeps within the
+// consent frequency, e.g. 4/DAY gives 6 hours.
+func FetchInterval(frequency types.ConsentPeriod) (time.Duration, error) {
+	period, ok := frequencyPeriods[frequency.Unit]
+	if !ok || frequency.Value < 1 {
+		return 0, fmt.Errorf("invalid frequency %+v", frequency)
+	}
+	return period / time.Duration(frequency.Value), nil
+}
+
+// NextSync decides whether the consent is due for a fetch at now, given its
+// latest run that reached the AA (lastRequested), its latest completed run and
+// how many of its runs failed. When due, from..to is the part of the consented
+// FIDataRange that has not been synced yet.
+func NextSync(consent types.Consent, lastRequested, lastCompleted *types.SyncRun, failures int, now time.Time) (from, to time.Time, due bool, err error) {
+	if consent.Status != types.ConsentActive {
+		return from, to, false, nil
+	}
+
+	if consent.Detail.ConsentExpiry != "" {
+		expiry, err := time.Parse(time.RFC3339, consent.Detail.ConsentExpiry)
+		if err != nil {
+			return from, to, false, fmt.Errorf("invalid consentExpiry %q", consent.Detail.ConsentExpiry)
+		}
+		if !now.Before(expiry) {
+			return from, to, false, nil
+		}
+	}
+
+	if consent.Detail.FetchType == "PERIODIC" {
+		interval, err := FetchInterval(consent.Detail.Frequency)
+		if err != nil {
+			return from, to, false, err
+		}
+		if lastRequested != nil && now.Sub(lastRequested.StartedAt) < interval {
+			return from, to, false, nil
+		}
+	} else if lastCompleted != nil || (lastRequested != nil && lastRequested.Status == types.SyncRequested) {
+		return from, to, false, nil
+	} else if failures > 0 {
+		if failures >= MaxOneTimeAttempts {
+			return from, to, false, nil
+		}
+		if lastRequested != nil && now.Sub(lastRequested.StartedAt) < oneTimeRetryDelay<<(failures-1) {
+			return from, to, false, nil
+		}
+	}
+
+	if from, err = time.Parse(time.RFC3339, consent.Detail.FIDataRange.From); err != nil {
+		return from, to, false, fmt.Errorf("invalid FIDataRange from %q", consent.Detail.FIDataRange.From)
+	}
+	if to, err = time.Parse(time.RFC3339, consent.Detail.FIDataRange.To); err != nil {
+		return from, to, false, fmt.Errorf("invalid FIDataRange to %q", consent.Detail.FIDataRange.To)
+	}
+
+	if lastCompleted != nil && lastCompleted.DataTo.After(from) {
+		from = lastCompleted.DataTo
+	}
+	if now.Before(to) {
+		to = now
+	}
+	return from, to, from.Before(to), nil
+}
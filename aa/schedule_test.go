@@ -0,0 +1,49 @@
+package aa
+
+import (
+	"testing"
+	"time"
+
+	"valyx/aggregator/types"
+)
+
+func TestNextSyncOneTimeRetries(t *testing.T) {
+	now := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	consent := types.Consent{
+		Status: types.ConsentActive,
+		Detail: types.ConsentDetail{
+			FetchType:   "ONETIME",
+			FIDataRange: types.DateRange{From: "2023-01-01T00:00:00Z", To: "2023-08-31T00:00:00Z"},
+		},
+	}
+	failedAgo := func(d time.Duration) *types.SyncRun {
+		return &types.SyncRun{Status: types.SyncFailed, StartedAt: now.Add(-d)}
+	}
+
+	tests := []struct {
+		name          string
+		lastRequested *types.SyncRun
+		lastCompleted *types.SyncRun
+		failures      int
+		due           bool
+	}{
+		{"never requested", nil, nil, 0, true},
+		{"waiting on its session", &types.SyncRun{Status: types.SyncRequested, StartedAt: now}, nil, 0, false},
+		{"completed", &types.SyncRun{Status: types.SyncCompleted}, &types.SyncRun{Status: types.SyncCompleted}, 0, false},
+		{"first failure, too soon", failedAgo(10 * time.Minute), nil, 1, false},
+		{"first failure, retry", failedAgo(15 * time.Minute), nil, 1, true},
+		{"third failure backs off", failedAgo(45 * time.Minute), nil, 3, false},
+		{"third failure, retry", failedAgo(time.Hour), nil, 3, true},
+		{"out of attempts", failedAgo(24 * time.Hour), nil, MaxOneTimeAttempts, false},
+	}
+
+	for _, test := range tests {
+		_, _, due, err := NextSync(consent, test.lastRequested, test.lastCompleted, test.failures, now)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if due != test.due {
+			t.Errorf("%s: due = %v, want %v", test.name, due, test.due)
+		}
+	}
+}
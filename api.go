@@ -1,27 +1,75 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"valyx/aggregator/aa"
 	"valyx/aggregator/types"
+	"valyx/aggregator/utils"
 
 	"github.com/spf13/viper"
 )
 
 type Server struct {
-	QueryService *Service
+	QueryService   *Service
+	ConsentManager *aa.ConsentManager
+	ConsentStore   types.ConsentStore
+	DataFetcher    *aa.DataFetcher
+	Importer       *utils.Processor
+	SyncStore      types.SyncStore
+	Quarantine     types.QuarantineStore
+	Ingestions     types.IngestionStore
+
+	// syncMu keeps the scheduler and notifications from requesting the same
+	// consent's data twice.
+	syncMu sync.Mutex
+	// ingestMu runs one ingestion job at a time; the others wait queued.
+	ingestMu sync.Mutex
+	// quarantineLocks lets one action at a time rewrite a quarantined file.
+	quarantineLocks utils.KeyLocks
+
+	// ctx is cancelled at shutdown; jobs counts the work started with
+	// goBackground, which main waits for before exiting.
+	ctx  context.Context
+	jobs sync.WaitGroup
 }
 
-func NewServer(queryService *Service) *Server {
+func NewServer(ctx context.Context, queryService *Service, consentManager *aa.ConsentManager, consentStore types.ConsentStore, dataFetcher *aa.DataFetcher, importer *utils.Processor, syncStore types.SyncStore, quarantine types.QuarantineStore, ingestions types.IngestionStore) *Server {
 
 	return &Server{
-		QueryService: queryService,
+		QueryService:   queryService,
+		ConsentManager: consentManager,
+		ConsentStore:   consentStore,
+		DataFetcher:    dataFetcher,
+		Importer:       importer,
+		SyncStore:      syncStore,
+		Quarantine:     quarantine,
+		Ingestions:     ingestions,
+		ctx:            ctx,
 	}
 }
 
+// goBackground runs job on its own goroutine with the server's context. Jobs
+// are expected to return soon after the context is cancelled.
+func (s *Server) goBackground(job func(ctx context.Context)) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		job(s.ctx)
+	}()
+}
+
 func (s *Server) TestEnvironmentHandler(w http.ResponseWriter, r *http.Request) {
 	results := viper.GetString("TEST_ENV")
 
@@ -176,3 +224,564 @@ func (s *Server) AggregateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (s *Server) ConsentsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var params aa.ConsentParams
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, "Invalid consent request body", http.StatusBadRequest)
+			return
+		}
+
+		consent, err := s.ConsentManager.RequestConsent(params)
+		var invalid *aa.InvalidParamsError
+		if errors.As(err, &invalid) {
+			http.Error(w, fmt.Sprintf("Invalid consent request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to request consent: %v", err), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(consent); err != nil {
+			http.Error(w, "Failed to encode consent", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodGet:
+		consents, err := s.ConsentStore.ListConsents()
+		if err != nil {
+			http.Error(w, "Failed to fetch consents", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(consents); err != nil {
+			http.Error(w, "Failed to encode consents", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ConsentStatusHandler refreshes a consent from the AA by its handle,
+// e.g. GET /consents/{consentHandle}.
+func (s *Server) ConsentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	consentHandle := strings.TrimPrefix(r.URL.Path, "/consents/")
+	if consentHandle == "" {
+		http.Error(w, "Missing consent handle", http.StatusBadRequest)
+		return
+	}
+
+	previous, err := s.ConsentStore.GetConsent(consentHandle)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Consent %s not found", consentHandle), http.StatusNotFound)
+		return
+	}
+
+	consent, err := s.ConsentManager.RefreshConsent(consentHandle)
+	var aaErr *aa.ErrorResponse
+	if errors.As(err, &aaErr) && (aaErr.ErrorCode == "InvalidConsentHandle" || aaErr.ErrorCode == "InvalidConsentId") {
+		http.Error(w, fmt.Sprintf("Consent %s not found at the AA", consentHandle), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to refresh consent: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	if consent.Status == types.ConsentActive && previous.Status != types.ConsentActive {
+		s.goBackground(func(ctx context.Context) { s.syncConsent(ctx, consent) })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(consent); err != nil {
+		http.Error(w, "Failed to encode consent", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ConsentNotificationHandler receives consent status changes pushed by the AA.
+// A consent turning ACTIVE is synced right away instead of on the next tick,
+// and a revoked one has its data purged right away.
+func (s *Server) ConsentNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var notification aa.ConsentNotification
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		writeAAError(w, http.StatusBadRequest, notification.TxnID, "InvalidRequest", "malformed consent notification")
+		return
+	}
+
+	previous, _ := s.ConsentStore.GetConsentByID(notification.ConsentStatusNotification.ConsentID)
+	consent, err := s.ConsentManager.ApplyNotification(notification.ConsentStatusNotification)
+	if err != nil {
+		writeAAError(w, http.StatusBadRequest, notification.TxnID, "InvalidRequest", err.Error())
+		return
+	}
+
+	switch {
+	case consent.Status == types.ConsentActive && previous.Status != types.ConsentActive:
+		s.goBackground(func(ctx context.Context) { s.syncConsent(ctx, consent) })
+	case consent.Status == types.ConsentRevoked || consent.Status == types.ConsentRejected:
+		s.goBackground(func(context.Context) { s.purgeLapsedData() })
+	}
+
+	writeAAResponse(w, aa.NewNotificationResponse(notification.TxnID))
+}
+
+// FINotificationHandler receives FI session updates from the AA and fetches,
+// decrypts and imports the data as soon as it is ready.
+func (s *Server) FINotificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var notification aa.FINotification
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		writeAAError(w, http.StatusBadRequest, notification.TxnID, "InvalidRequest", "malformed FI notification")
+		return
+	}
+
+	status := notification.FIStatusNotification
+	if status.SessionID == "" {
+		writeAAError(w, http.StatusBadRequest, notification.TxnID, "InvalidSessionId", "sessionId is required")
+		return
+	}
+
+	switch {
+	case status.DataReady():
+		s.goBackground(func(context.Context) { s.fetchFIData(status.SessionID) })
+	case status.SessionStatus == aa.SessionFailed || status.SessionStatus == aa.SessionExpired:
+		s.goBackground(func(context.Context) {
+			s.failFISession(status.SessionID, "AA reported FI session "+status.SessionStatus)
+		})
+	}
+
+	writeAAResponse(w, aa.NewNotificationResponse(notification.TxnID))
+}
+
+// SyncRunsHandler lists the latest FI sync runs, optionally for one consent:
+// GET /syncRuns?consentId=...&limit=50.
+func (s *Server) SyncRunsHandler(w http.ResponseWriter, r *http.Request) {
+	consentID := r.URL.Query().Get("consentId")
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 500 {
+			http.Error(w, "Invalid limit parameter. It must be a number between 1 and 500.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	runs, err := s.SyncStore.ListSyncRuns(consentID, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch sync runs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(runs); err != nil {
+		http.Error(w, "Failed to encode sync runs", http.StatusInternalServerError)
+		return
+	}
+}
+
+// maxUploadSize bounds a POST /statements request, files included.
+const maxUploadSize = 32 << 20
+
+// StatementsHandler queues an ingestion job for statements uploaded as
+// multipart "files", zip archives and emails among them, optionally read with
+// the "profile" named, and answers 202 with the job. The account is the one
+// in "accountId" if given, else the one an OFX, MT940, camt or ReBIT file
+// names, else the key of the account number in "accountNumber" or each
+// statement's header block, else the file name. "accountHolder", "ifsc" and
+// "branch" fill in details the statements do not print.
+func (s *Server) StatementsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid multipart upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	accountId := strings.TrimSpace(r.FormValue("accountId"))
+	account := utils.StatementAccount{
+		Number: strings.TrimSpace(r.FormValue("accountNumber")),
+		Holder: strings.TrimSpace(r.FormValue("accountHolder")),
+		IFSC:   strings.ToUpper(strings.TrimSpace(r.FormValue("ifsc"))),
+		Branch: strings.TrimSpace(r.FormValue("branch")),
+	}
+	profile := strings.TrimSpace(r.FormValue("profile"))
+	if profile != "" && !s.Importer.HasProfile(profile) {
+		http.Error(w, fmt.Sprintf("Unknown statement profile %s", profile), http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 {
+		http.Error(w, "No statement files uploaded", http.StatusBadRequest)
+		return
+	}
+	for _, header := range files {
+		if ext := filepath.Ext(header.Filename); !s.Importer.Supports(ext) {
+			http.Error(w, fmt.Sprintf("Unsupported statement format %q of %s", ext, header.Filename), http.StatusBadRequest)
+			return
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "ingestion-")
+	if err != nil {
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	tasks := make([]utils.IngestTask, 0, len(files))
+	for i, header := range files {
+		path, err := saveUpload(header, filepath.Join(dir, strconv.Itoa(i)))
+		if err != nil {
+			os.RemoveAll(dir)
+			http.Error(w, fmt.Sprintf("Failed to store upload: %v", err), http.StatusInternalServerError)
+			return
+		}
+		tasks = append(tasks, utils.IngestTask{Path: path, AccountID: accountId, Profile: profile, Account: account})
+	}
+
+	job := types.IngestionJob{AccountID: accountId, Profile: profile, Status: types.IngestionQueued, Files: len(tasks)}
+	if err := s.Ingestions.CreateIngestion(&job); err != nil {
+		os.RemoveAll(dir)
+		http.Error(w, "Failed to create ingestion job", http.StatusInternalServerError)
+		return
+	}
+	s.goBackground(func(ctx context.Context) { s.runIngestion(ctx, job, dir, tasks) })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/ingestions/%d", job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, "Failed to encode ingestion job", http.StatusInternalServerError)
+		return
+	}
+}
+
+// saveUpload copies an uploaded file into dir under its own name, which
+// profiles may match on.
+func saveUpload(header *multipart.FileHeader, dir string) (string, error) {
+	src, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, filepath.Base(header.Filename))
+	dst, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	return path, dst.Close()
+}
+
+func (s *Server) IngestionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := strings.ToUpper(r.URL.Query().Get("status"))
+	if status != "" && !validStatus(status, types.IngestionStatuses) {
+		http.Error(w, fmt.Sprintf("Invalid status parameter. It must be one of %s.", strings.Join(types.IngestionStatuses, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 500 {
+			http.Error(w, "Invalid limit parameter. It must be a number between 1 and 500.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	jobs, err := s.Ingestions.ListIngestions(status, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch ingestion jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(jobs); err != nil {
+		http.Error(w, "Failed to encode ingestion jobs", http.StatusInternalServerError)
+		return
+	}
+}
+
+func validStatus(status string, statuses []string) bool {
+	for _, valid := range statuses {
+		if status == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// IngestionStatusHandler serves GET /ingestions/{id}.
+func (s *Server) IngestionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/ingestions/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ingestion job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.Ingestions.GetIngestion(id)
+	if errors.Is(err, types.ErrNotFound) {
+		http.Error(w, fmt.Sprintf("Ingestion job %d not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch ingestion job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, "Failed to encode ingestion job", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) QuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := strings.ToUpper(r.URL.Query().Get("status"))
+	if status != "" && !validStatus(status, types.QuarantineStatuses) {
+		http.Error(w, fmt.Sprintf("Invalid status parameter. It must be one of %s.", strings.Join(types.QuarantineStatuses, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 500 {
+			http.Error(w, "Invalid limit parameter. It must be a number between 1 and 500.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := s.Quarantine.ListQuarantinedRows(status, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch quarantined rows", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rows); err != nil {
+		http.Error(w, "Failed to encode quarantined rows", http.StatusInternalServerError)
+		return
+	}
+}
+
+// QuarantineActionHandler serves POST /quarantine/{id}/resubmit, optionally
+// with a corrected {"record": [...]}, and POST /quarantine/{id}/discard.
+// Either imports the row's file again with the change, resolving every row
+// of it that now goes in.
+func (s *Server) QuarantineActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/quarantine/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, "Expected /quarantine/{id}/resubmit or /quarantine/{id}/discard", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid quarantined row id", http.StatusBadRequest)
+		return
+	}
+
+	row, err := s.Quarantine.GetQuarantinedRow(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Quarantined row %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	// The row is read again under its file's lock, so an action that got
+	// there first is seen rather than overwritten.
+	key := fmt.Sprintf("row %d", row.ID)
+	if row.FileID != 0 {
+		key = fmt.Sprintf("file %d", row.FileID)
+	}
+	defer s.quarantineLocks.Lock(key)()
+	if row, err = s.Quarantine.GetQuarantinedRow(id); err != nil {
+		http.Error(w, fmt.Sprintf("Quarantined row %d not found", id), http.StatusNotFound)
+		return
+	}
+	if row.Status != types.QuarantinePending {
+		http.Error(w, fmt.Sprintf("Quarantined row %d is already %s", id, row.Status), http.StatusConflict)
+		return
+	}
+
+	file, err := s.quarantinedFile(row)
+	if err != nil {
+		http.Error(w, "Failed to fetch quarantined file", http.StatusInternalServerError)
+		return
+	}
+	index := row.Line - file.FirstLine
+	if index < 0 || index >= len(file.Records) {
+		http.Error(w, fmt.Sprintf("Quarantined row %d is not part of its file", id), http.StatusInternalServerError)
+		return
+	}
+
+	switch parts[1] {
+	case "resubmit":
+		var req struct {
+			Record []string `json:"record"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Record != nil {
+			file.Records[index] = req.Record
+		}
+	case "discard":
+		file.Records[index] = nil
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+
+	// The whole file is imported again, so the other rows land with it.
+	report, err := s.Importer.ResubmitFile(file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to import %s: %v", file.File, err), http.StatusInternalServerError)
+		return
+	}
+	if file.ID != 0 {
+		if err := s.Quarantine.UpdateQuarantinedFile(file); err != nil {
+			http.Error(w, "Failed to update quarantined file", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	pending := []types.QuarantinedRow{row}
+	if file.ID != 0 {
+		if pending, err = s.Quarantine.FileQuarantinedRows(file.ID); err != nil {
+			http.Error(w, "Failed to fetch quarantined rows", http.StatusInternalServerError)
+			return
+		}
+	}
+	failed := make(map[int]string, len(report.Errors))
+	for _, rowErr := range report.Errors {
+		failed[rowErr.Line] = rowErr.Reason
+	}
+	for _, pendingRow := range pending {
+		if record := file.Records[pendingRow.Line-file.FirstLine]; record != nil {
+			pendingRow.Record = record
+		}
+		reason, stillFails := failed[pendingRow.Line]
+		switch {
+		case pendingRow.ID == row.ID && parts[1] == "discard":
+			pendingRow.Status = types.QuarantineDiscarded
+		case stillFails:
+			pendingRow.Reason = reason
+		case report.Rejected:
+			pendingRow.Reason = "waiting on other rows of the file that do not parse"
+		default:
+			pendingRow.Status = types.QuarantineResubmitted
+		}
+		if err := s.Quarantine.UpdateQuarantinedRow(pendingRow); err != nil {
+			http.Error(w, "Failed to update quarantined row", http.StatusInternalServerError)
+			return
+		}
+		if pendingRow.ID == row.ID {
+			row = pendingRow
+		}
+	}
+
+	if row.Status == types.QuarantinePending {
+		http.Error(w, fmt.Sprintf("Row was not imported: %s", row.Reason), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(row); err != nil {
+		http.Error(w, "Failed to encode quarantined row", http.StatusInternalServerError)
+		return
+	}
+}
+
+// quarantinedFile returns the file a quarantined row belongs to. A row kept
+// before whole files were is treated as a file of its own.
+func (s *Server) quarantinedFile(row types.QuarantinedRow) (types.QuarantinedFile, error) {
+	if row.FileID != 0 {
+		return s.Quarantine.GetQuarantinedFile(row.FileID)
+	}
+	return types.QuarantinedFile{
+		File:      row.File,
+		AccountID: row.AccountID,
+		Mapping:   row.Mapping,
+		Records:   [][]string{row.Record},
+		FirstLine: row.Line,
+		Policy:    utils.PolicySkip,
+	}, nil
+}
+
+func writeAAResponse(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeAAError(w http.ResponseWriter, status int, txnID, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(aa.ErrorResponse{
+		Ver:       aa.APIVersion,
+		Timestamp: aa.Timestamp(time.Now()),
+		TxnID:     txnID,
+		ErrorCode: code,
+		ErrorMsg:  msg,
+	})
+}
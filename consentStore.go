@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+	"valyx/aggregator/types"
+)
+
+func (db *PostgresDB) createConsentTable() error {
+	query := `CREATE TABLE IF NOT EXISTS consents (
+        Consent_Handle TEXT PRIMARY KEY,
+        Consent_Id TEXT UNIQUE,
+        Status TEXT NOT NULL,
+        Detail JSONB NOT NULL,
+        Signed_Consent TEXT,
+        Created_At TIMESTAMPTZ NOT NULL,
+        Updated_At TIMESTAMPTZ NOT NULL,
+        Approved_At TIMESTAMPTZ
+    )`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+	_, err := db.Exec(`ALTER TABLE consents ADD COLUMN IF NOT EXISTS approved_at TIMESTAMPTZ`)
+	return err
+}
+
+// createConsentAccountTable holds the accounts and date range each consent
+// covers, which is what queries are scoped to.
+func (db *PostgresDB) createConsentAccountTable() error {
+	query := `CREATE TABLE IF NOT EXISTS consent_accounts (
+        Consent_Id TEXT NOT NULL,
+        Account_Id TEXT NOT NULL,
+        Data_From DATE NOT NULL,
+        Data_To DATE NOT NULL,
+        PRIMARY KEY (Consent_Id, Account_Id)
+    )`
+	_, err := db.Exec(query)
+	return err
+}
+
+func (db *PostgresDB) SaveConsent(c types.Consent) error {
+	detail, err := json.Marshal(c.Detail)
+	if err != nil {
+		return fmt.Errorf("error encoding consent detail: %v", err)
+	}
+
+	// approved_at is when the consent was first seen ACTIVE, which is where
+	// its DataLife starts.
+	query := fmt.Sprintf(`
+        INSERT INTO consents (consent_handle, consent_id, status, detail, signed_consent, created_at, updated_at, approved_at)
+        VALUES ($1, NULLIF($2, ''), $3, $4, NULLIF($5, ''), $6, $7, CASE WHEN $3 = '%s' THEN $7::TIMESTAMPTZ END)
+        ON CONFLICT (consent_handle) DO UPDATE SET
+            consent_id = EXCLUDED.consent_id,
+            status = EXCLUDED.status,
+            detail = EXCLUDED.detail,
+            signed_consent = EXCLUDED.signed_consent,
+            updated_at = EXCLUDED.updated_at,
+            approved_at = COALESCE(consents.approved_at, EXCLUDED.approved_at)
+    `, types.ConsentActive)
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error saving consent: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(query, c.ConsentHandle, c.ConsentID, c.Status, detail, c.SignedConsent, c.CreatedAt, c.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error saving consent: %v", err)
+	}
+	if err := saveConsentAccounts(tx, c); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveConsentAccounts records the accounts an approved consent covers. They
+// are keyed by link reference, the account ID FI data is imported under.
+func saveConsentAccounts(tx *sql.Tx, c types.Consent) error {
+	if c.ConsentID == "" || len(c.Detail.Accounts) == 0 {
+		return nil
+	}
+
+	from, err := time.Parse(time.RFC3339, c.Detail.FIDataRange.From)
+	if err != nil {
+		return fmt.Errorf("invalid FIDataRange from %q", c.Detail.FIDataRange.From)
+	}
+	to, err := time.Parse(time.RFC3339, c.Detail.FIDataRange.To)
+	if err != nil {
+		return fmt.Errorf("invalid FIDataRange to %q", c.Detail.FIDataRange.To)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM consent_accounts WHERE consent_id = $1`, c.ConsentID); err != nil {
+		return fmt.Errorf("error saving consent accounts: %v", err)
+	}
+	for _, account := range c.Detail.Accounts {
+		accountId := account.LinkRefNumber
+		if accountId == "" {
+			accountId = account.MaskedAccNumber
+		}
+		_, err := tx.Exec(`
+            INSERT INTO consent_accounts (consent_id, account_id, data_from, data_to)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (consent_id, account_id) DO NOTHING
+        `, c.ConsentID, accountId, from.Format("2006-01-02"), to.Format("2006-01-02"))
+		if err != nil {
+			return fmt.Errorf("error saving consent account %s: %v", accountId, err)
+		}
+	}
+	return nil
+}
+
+func (db *PostgresDB) GetConsent(consentHandle string) (types.Consent, error) {
+	const query = `
+        SELECT consent_handle, COALESCE(consent_id, ''), status, detail, COALESCE(signed_consent, ''), created_at, updated_at
+        FROM consents
+        WHERE consent_handle = $1
+    `
+	c, err := scanConsent(db.QueryRow(query, consentHandle))
+	if err == sql.ErrNoRows {
+		return types.Consent{}, fmt.Errorf("consent %s not found", consentHandle)
+	}
+	return c, err
+}
+
+func (db *PostgresDB) GetConsentByID(consentID string) (types.Consent, error) {
+	const query = `
+        SELECT consent_handle, COALESCE(consent_id, ''), status, detail, COALESCE(signed_consent, ''), created_at, updated_at
+        FROM consents
+        WHERE consent_id = $1
+    `
+	c, err := scanConsent(db.QueryRow(query, consentID))
+	if err == sql.ErrNoRows {
+		return types.Consent{}, fmt.Errorf("consent %s not found", consentID)
+	}
+	return c, err
+}
+
+func (db *PostgresDB) ListConsents() ([]types.Consent, error) {
+	const query = `
+        SELECT consent_handle, COALESCE(consent_id, ''), status, detail, COALESCE(signed_consent, ''), created_at, updated_at
+        FROM consents
+        ORDER BY created_at DESC
+    `
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying consents: %v", err)
+	}
+	defer rows.Close()
+
+	var consents []types.Consent
+	for rows.Next() {
+		c, err := scanConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error with rows during consent fetching: %v", err)
+	}
+
+	return consents, nil
+}
+
+// consentScope restricts a query on transactions to the rows an active
+// consent covers. Accounts never shared under a consent, such as those
+// imported from statements, are only let through when ExemptStatements is
+// set. It is empty when ENFORCE_CONSENT is off.
+func (db *PostgresDB) consentScope() string {
+	if !db.EnforceConsent {
+		return ""
+	}
+	exempt := ""
+	if db.ExemptStatements {
+		exempt = `transactions.account_id NOT IN (SELECT account_id FROM consent_accounts)
+            OR `
+	}
+	return fmt.Sprintf(` AND (%sEXISTS (
+            SELECT 1 FROM consent_accounts ca
+            JOIN consents c ON c.consent_id = ca.consent_id
+            WHERE c.status = '%s'
+              AND ca.account_id = transactions.account_id
+              AND transactions.date BETWEEN ca.data_from AND ca.data_to))`, exempt, types.ConsentActive)
+}
+
+// PurgeLapsedData deletes the data of accounts that were shared under a
+// consent but are no longer covered by one we may retain: consents that were
+// revoked, rejected or have expired, or whose DataLife has run out. DataLife
+// counts from the later of the consent's approval and its last completed
+// fetch; consents saved before approvals were recorded count from their
+// creation. Accounts never shared under a consent are left alone.
+func (db *PostgresDB) PurgeLapsedData() (int64, error) {
+	retained := fmt.Sprintf(`
+        SELECT ca.account_id, ca.data_from, ca.data_to
+        FROM consent_accounts ca
+        JOIN consents c ON c.consent_id = ca.consent_id
+        WHERE c.status NOT IN ('%s', '%s', '%s')
+          AND (c.detail->'DataLife'->>'unit' = 'INF'
+               OR GREATEST(COALESCE(c.approved_at, c.created_at),
+                           (SELECT MAX(r.finished_at) FROM sync_runs r WHERE r.consent_id = c.consent_id AND r.status = '%s'))
+                  + ((c.detail->'DataLife'->>'value') || ' ' || (c.detail->'DataLife'->>'unit'))::INTERVAL > NOW())`,
+		types.ConsentRevoked, types.ConsentRejected, types.ConsentExpired, types.SyncCompleted)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error purging lapsed data: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        DELETE FROM transactions t
+        WHERE t.account_id IN (SELECT account_id FROM consent_accounts)
+          AND NOT EXISTS (
+            SELECT 1 FROM (` + retained + `) keep
+            WHERE keep.account_id = t.account_id AND t.date BETWEEN keep.data_from AND keep.data_to)`)
+	if err != nil {
+		return 0, fmt.Errorf("error purging lapsed transactions: %v", err)
+	}
+	purged, _ := result.RowsAffected()
+
+	_, err = tx.Exec(`
+        DELETE FROM account_summaries s
+        WHERE s.account_id IN (SELECT account_id FROM consent_accounts)
+          AND s.account_id NOT IN (SELECT account_id FROM (` + retained + `) keep)`)
+	if err != nil {
+		return 0, fmt.Errorf("error purging lapsed account summaries: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error purging lapsed data: %v", err)
+	}
+	return purged, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanConsent(row rowScanner) (types.Consent, error) {
+	var c types.Consent
+	var detail []byte
+	if err := row.Scan(&c.ConsentHandle, &c.ConsentID, &c.Status, &detail, &c.SignedConsent, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return c, err
+		}
+		return c, fmt.Errorf("error scanning consent: %v", err)
+	}
+	if err := json.Unmarshal(detail, &c.Detail); err != nil {
+		return c, fmt.Errorf("error decoding consent detail: %v", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConsentScope(t *testing.T) {
+	const exemption = "transactions.account_id NOT IN (SELECT account_id FROM consent_accounts)"
+	const covered = "ca.account_id = transactions.account_id"
+
+	db := &PostgresDB{EnforceConsent: true}
+	scope := db.consentScope()
+	if !strings.Contains(scope, covered) || !strings.Contains(scope, "c.status = 'ACTIVE'") {
+		t.Errorf("scope does not require an active consent: %s", scope)
+	}
+	if strings.Contains(scope, exemption) {
+		t.Errorf("accounts without a consent pass by default: %s", scope)
+	}
+
+	db.ExemptStatements = true
+	scope = db.consentScope()
+	if !strings.Contains(scope, exemption+"\n            OR EXISTS") || !strings.Contains(scope, covered) {
+		t.Errorf("accounts without a consent not exempted: %s", scope)
+	}
+
+	db.EnforceConsent = false
+	if scope := db.consentScope(); scope != "" {
+		t.Errorf("scope applied with ENFORCE_CONSENT off: %s", scope)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"valyx/aggregator/aa"
+	"valyx/aggregator/aa/aacrypto"
+	"valyx/aggregator/types"
+	"valyx/aggregator/utils"
+)
+
+// syncDueConsents requests FI data for every active consent whose frequency
+// allows another fetch. Runs whose session outlived our key are failed first,
+// since their data can no longer be decrypted.
+func (s *Server) syncDueConsents(ctx context.Context) {
+	if err := s.SyncStore.ExpireSyncRuns(time.Now().Add(-aacrypto.KeyExpiry)); err != nil {
+		log.Printf("sync: %v", err)
+	}
+
+	consents, err := s.ConsentStore.ListConsents()
+	if err != nil {
+		log.Printf("sync: error listing consents: %v", err)
+		return
+	}
+
+	for _, consent := range consents {
+		if consent.Status == types.ConsentActive {
+			s.syncConsent(ctx, consent)
+		}
+	}
+}
+
+// purgeLapsedData removes data whose consent was revoked or expired, or whose
+// DataLife ran out.
+func (s *Server) purgeLapsedData() {
+	purged, err := s.ConsentStore.PurgeLapsedData()
+	if err != nil {
+		log.Printf("purge: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("purge: removed %d transactions no longer covered by a consent", purged)
+	}
+}
+
+// syncConsent requests the data of the consent not synced yet, if the
+// consent frequency allows a fetch now. Nothing is requested once ctx is
+// cancelled.
+func (s *Server) syncConsent(ctx context.Context, consent types.Consent) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+
+	lastRequested, err := s.SyncStore.LastSyncRun(consent.ConsentID, types.SyncRequested, types.SyncCompleted, types.SyncFailed)
+	if err != nil {
+		log.Printf("consent %s: %v", consent.ConsentID, err)
+		return
+	}
+	lastCompleted, err := s.SyncStore.LastSyncRun(consent.ConsentID, types.SyncCompleted)
+	if err != nil {
+		log.Printf("consent %s: %v", consent.ConsentID, err)
+		return
+	}
+
+	failures, err := s.SyncStore.CountSyncRuns(consent.ConsentID, types.SyncFailed)
+	if err != nil {
+		log.Printf("consent %s: %v", consent.ConsentID, err)
+		return
+	}
+
+	from, to, due, err := aa.NextSync(consent, lastRequested, lastCompleted, failures, time.Now())
+	if err != nil {
+		log.Printf("consent %s: %v", consent.ConsentID, err)
+		return
+	}
+	if due {
+		s.requestFIData(consent, from, to)
+	}
+}
+
+// requestFIData opens an FI data session for from..to under the consent and
+// records it as a sync run. The data itself is fetched once the AA notifies us
+// that it is ready.
+func (s *Server) requestFIData(consent types.Consent, from, to time.Time) {
+	run := types.SyncRun{
+		ConsentID: consent.ConsentID,
+		Status:    types.SyncRequested,
+		DataFrom:  from,
+		DataTo:    to,
+		StartedAt: time.Now(),
+	}
+	if err := s.SyncStore.CreateSyncRun(&run); err != nil {
+		log.Printf("consent %s: %v", consent.ConsentID, err)
+		return
+	}
+
+	sessionID, err := s.DataFetcher.RequestData(consent, from, to)
+	if err != nil {
+		log.Printf("consent %s: error requesting FI data: %v", consent.ConsentID, err)
+		s.finishSyncRun(run, types.SyncFailed, err.Error())
+		return
+	}
+
+	run.SessionID = sessionID
+	run.PrivateKey, run.Nonce, _ = s.DataFetcher.SessionKey(sessionID)
+	if err := s.SyncStore.UpdateSyncRun(run); err != nil {
+		log.Printf("consent %s: %v", consent.ConsentID, err)
+	}
+	log.Printf("consent %s: requested FI data from %s to %s, session %s", consent.ConsentID, from.Format("2006-01-02"), to.Format("2006-01-02"), sessionID)
+}
+
+// resumeFISessions reopens the sessions requested before a restart, so their
+// data can still be fetched when the AA notifies us. A run whose key was not
+// stored can never be decrypted and is failed.
+func (s *Server) resumeFISessions() {
+	runs, err := s.SyncStore.PendingSyncRuns()
+	if err != nil {
+		log.Printf("sync: %v", err)
+		return
+	}
+
+	for _, run := range runs {
+		err := s.DataFetcher.ResumeSession(run.SessionID, run.ConsentID, run.PrivateKey, run.Nonce, run.StartedAt.Add(aacrypto.KeyExpiry))
+		if err != nil {
+			log.Printf("FI session %s: %v", run.SessionID, err)
+			s.finishSyncRun(run, types.SyncFailed, "FI session key lost on restart")
+		}
+	}
+}
+
+// fetchFIData fetches and decrypts the data of an FI session, imports every
+// account in it and completes the session's sync run.
+func (s *Server) fetchFIData(sessionID string) {
+	run, err := s.SyncStore.GetSyncRunBySession(sessionID)
+	if err != nil {
+		log.Printf("FI session %s: %v", sessionID, err)
+		return
+	}
+
+	payloads, err := s.DataFetcher.FetchData(sessionID)
+	if err != nil {
+		log.Printf("FI session %s: %v", sessionID, err)
+		return
+	}
+
+	var failures []string
+	for _, payload := range payloads {
+		statement, err := utils.ParseFIData(payload.Data)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s from %s: %v", payload.MaskedAccNumber, payload.FIPID, err))
+			continue
+		}
+
+		accountId := payload.LinkRefNumber
+		if accountId == "" {
+			accountId = payload.MaskedAccNumber
+		}
+		if err := s.Importer.IngestStatement(statement, accountId); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", accountId, err))
+			continue
+		}
+		run.Transactions += len(statement.Transactions)
+		log.Printf("FI session %s: imported %d transactions for %s", sessionID, len(statement.Transactions), accountId)
+	}
+
+	if len(failures) > 0 {
+		log.Printf("FI session %s: %s", sessionID, strings.Join(failures, "; "))
+		s.finishSyncRun(run, types.SyncFailed, strings.Join(failures, "; "))
+		return
+	}
+	s.finishSyncRun(run, types.SyncCompleted, "")
+}
+
+// failFISession records that the AA gave up on a session.
+func (s *Server) failFISession(sessionID, reason string) {
+	run, err := s.SyncStore.GetSyncRunBySession(sessionID)
+	if err != nil {
+		log.Printf("FI session %s: %v", sessionID, err)
+		return
+	}
+	if run.Status == types.SyncRequested {
+		s.finishSyncRun(run, types.SyncFailed, reason)
+	}
+}
+
+func (s *Server) finishSyncRun(run types.SyncRun, status, reason string) {
+	finishedAt := time.Now()
+	run.Status = status
+	run.Error = reason
+	run.FinishedAt = &finishedAt
+	if err := s.SyncStore.UpdateSyncRun(run); err != nil {
+		log.Printf("consent %s: %v", run.ConsentID, err)
+	}
+}
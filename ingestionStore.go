@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"valyx/aggregator/types"
+)
+
+func (db *PostgresDB) createIngestionTable() error {
+	query := `CREATE TABLE IF NOT EXISTS ingestion_jobs (
+        Id BIGSERIAL PRIMARY KEY,
+        Account_Id TEXT NOT NULL,
+        Profile TEXT,
+        Status TEXT NOT NULL,
+        Files INT NOT NULL DEFAULT 0,
+        Files_Done INT NOT NULL DEFAULT 0,
+        Rows INT NOT NULL DEFAULT 0,
+        Inserted INT NOT NULL DEFAULT 0,
+        Duplicates INT NOT NULL DEFAULT 0,
+        Rejected INT NOT NULL DEFAULT 0,
+        Reports JSONB,
+        Error TEXT,
+        Created_At TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        Started_At TIMESTAMPTZ,
+        Finished_At TIMESTAMPTZ
+    )`
+	_, err := db.Exec(query)
+	return err
+}
+
+const ingestionColumns = `id, account_id, COALESCE(profile, ''), status, files, files_done, rows, inserted, duplicates, rejected, reports, COALESCE(error, ''), created_at, started_at, finished_at`
+
+func (db *PostgresDB) CreateIngestion(job *types.IngestionJob) error {
+	const query = `
+        INSERT INTO ingestion_jobs (account_id, profile, status, files)
+        VALUES ($1, NULLIF($2, ''), $3, $4)
+        RETURNING id, created_at
+    `
+	err := db.QueryRow(query, job.AccountID, job.Profile, job.Status, job.Files).Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating ingestion job: %v", err)
+	}
+	return nil
+}
+
+func (db *PostgresDB) UpdateIngestion(job types.IngestionJob) error {
+	const query = `
+        UPDATE ingestion_jobs
+        SET status = $2, files_done = $3, rows = $4, inserted = $5, duplicates = $6, rejected = $7,
+            reports = $8, error = NULLIF($9, ''), started_at = $10, finished_at = $11
+        WHERE id = $1
+    `
+	var reports []byte
+	if len(job.Reports) > 0 {
+		reports = job.Reports
+	}
+	_, err := db.Exec(query, job.ID, job.Status, job.FilesDone, job.Rows, job.Inserted, job.Duplicates, job.Rejected,
+		reports, job.Error, job.StartedAt, job.FinishedAt)
+	if err != nil {
+		return fmt.Errorf("error updating ingestion job %d: %v", job.ID, err)
+	}
+	return nil
+}
+
+func (db *PostgresDB) GetIngestion(id int64) (types.IngestionJob, error) {
+	job, err := scanIngestion(db.QueryRow(`SELECT `+ingestionColumns+` FROM ingestion_jobs WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return types.IngestionJob{}, fmt.Errorf("ingestion job %d %w", id, types.ErrNotFound)
+	}
+	return job, err
+}
+
+func (db *PostgresDB) ListIngestions(status string, limit int) ([]types.IngestionJob, error) {
+	query := `SELECT ` + ingestionColumns + `
+        FROM ingestion_jobs
+        WHERE ($1 = '' OR status = $1)
+        ORDER BY created_at DESC, id DESC
+        LIMIT $2`
+	rows, err := db.Query(query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying ingestion jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var jobs []types.IngestionJob
+	for rows.Next() {
+		job, err := scanIngestion(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error with rows during ingestion job fetching: %v", err)
+	}
+
+	return jobs, nil
+}
+
+func (db *PostgresDB) FailInterruptedIngestions() error {
+	const query = `
+        UPDATE ingestion_jobs
+        SET status = $1, error = 'interrupted by a server restart', finished_at = NOW()
+        WHERE status IN ($2, $3)
+    `
+	if _, err := db.Exec(query, types.IngestionFailed, types.IngestionQueued, types.IngestionRunning); err != nil {
+		return fmt.Errorf("error failing interrupted ingestion jobs: %v", err)
+	}
+	return nil
+}
+
+func scanIngestion(row rowScanner) (types.IngestionJob, error) {
+	var job types.IngestionJob
+	var reports []byte
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.AccountID, &job.Profile, &job.Status, &job.Files, &job.FilesDone, &job.Rows,
+		&job.Inserted, &job.Duplicates, &job.Rejected, &reports, &job.Error, &job.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return job, err
+		}
+		return job, fmt.Errorf("error scanning ingestion job: %v", err)
+	}
+	job.Reports = reports
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
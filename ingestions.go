@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"valyx/aggregator/types"
+	"valyx/aggregator/utils"
+)
+
+// fileResult is the outcome of one file of an ingestion job.
+type fileResult struct {
+	*utils.IngestReport
+	Error string `json:"error,omitempty"`
+}
+
+// runIngestion runs the job over its uploaded files and removes the uploads
+// when done.
+func (s *Server) runIngestion(ctx context.Context, job types.IngestionJob, dir string, tasks []utils.IngestTask) {
+	defer os.RemoveAll(dir)
+	s.ingestFiles(ctx, &job, tasks)
+}
+
+// ingestFiles imports the files of a job one after the other, saving the
+// job's progress after each, and returns their outcome. Archives and emails
+// are unpacked first and count one file per statement in them. Under the
+// abort row error policy the first bad file stops the job, and cancelling ctx
+// fails it with the files not imported yet.
+func (s *Server) ingestFiles(ctx context.Context, job *types.IngestionJob, tasks []utils.IngestTask) []fileResult {
+	s.ingestMu.Lock()
+	defer s.ingestMu.Unlock()
+
+	startedAt := time.Now()
+	job.Status = types.IngestionRunning
+	job.StartedAt = &startedAt
+	s.saveIngestion(*job)
+
+	var results []fileResult
+	var failures []string
+	record := func(result fileResult) {
+		results = append(results, result)
+		job.FilesDone++
+		job.Rows += result.Rows
+		job.Inserted += result.Inserted
+		job.Duplicates += result.Duplicates
+		job.Rejected += len(result.Errors)
+		var err error
+		if job.Reports, err = json.Marshal(results); err != nil {
+			log.Printf("ingestion %d: error encoding reports: %v", job.ID, err)
+		}
+		if result.Error != "" {
+			failures = append(failures, fmt.Sprintf("%s: %s", result.File, result.Error))
+		}
+		if job.FilesDone < job.Files {
+			s.saveIngestion(*job)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "expand-")
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("error creating folder to unpack into: %v", err))
+		tasks = nil
+	}
+	defer os.RemoveAll(dir)
+
+files:
+	for _, task := range tasks {
+		members, err := s.Importer.Expand(task, dir)
+		// Every statement unpacked counts as a file, and so does a failure
+		// to unpack.
+		job.Files += len(members) - 1
+		if err != nil {
+			job.Files++
+			record(fileResult{IngestReport: &utils.IngestReport{File: filepath.Base(task.Path)}, Error: err.Error()})
+		}
+
+		for _, member := range members {
+			report, err := s.Importer.IngestFile(ctx, member)
+			var abort *utils.AbortError
+			result := fileResult{IngestReport: report}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			record(result)
+			if errors.As(err, &abort) || ctx.Err() != nil {
+				break files
+			}
+		}
+	}
+
+	if ctx.Err() != nil && job.FilesDone < job.Files {
+		failures = append(failures, fmt.Sprintf("interrupted after %d of %d files", job.FilesDone, job.Files))
+	}
+
+	finishedAt := time.Now()
+	job.Status = types.IngestionSucceeded
+	job.FinishedAt = &finishedAt
+	if len(failures) > 0 {
+		job.Status = types.IngestionFailed
+		job.Error = strings.Join(failures, "; ")
+	}
+	s.saveIngestion(*job)
+	log.Printf("ingestion %d: %s, %d of %d files, %d rows inserted, %d duplicates, %d rejected",
+		job.ID, strings.ToLower(job.Status), job.FilesDone, job.Files, job.Inserted, job.Duplicates, job.Rejected)
+	return results
+}
+
+func (s *Server) saveIngestion(job types.IngestionJob) {
+	if err := s.Ingestions.UpdateIngestion(job); err != nil {
+		log.Printf("ingestion %d: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"valyx/aggregator/types"
+	"valyx/aggregator/utils"
+)
+
+// cancellingDB stores nothing and cancels the import once a file is stored.
+type cancellingDB struct {
+	types.DB
+	cancel context.CancelFunc
+}
+
+func (db *cancellingDB) InsertBatch(b types.Batch) (int, error) {
+	db.cancel()
+	return len(b.Transactions), nil
+}
+
+type ingestionLog struct {
+	types.IngestionStore
+	saved []types.IngestionJob
+}
+
+func (l *ingestionLog) UpdateIngestion(job types.IngestionJob) error {
+	l.saved = append(l.saved, job)
+	return nil
+}
+
+func TestIngestFilesInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ingestions := &ingestionLog{}
+	s := NewServer(ctx, nil, nil, nil, nil, utils.NewProcessor(&cancellingDB{cancel: cancel}), nil, nil, ingestions)
+
+	dir := t.TempDir()
+	var tasks []utils.IngestTask
+	for _, name := range []string{"aug.csv", "sep.csv"} {
+		path := filepath.Join(dir, name)
+		statement := "Date,Description,Debit,Credit,Balance\n05/08/2023,ATM,100.00,,900.00\n"
+		if err := os.WriteFile(path, []byte(statement), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, utils.IngestTask{Path: path, AccountID: "hdfc"})
+	}
+
+	job := types.IngestionJob{ID: 1, Files: len(tasks)}
+	results := s.ingestFiles(ctx, &job, tasks)
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("results = %+v", results)
+	}
+	saved := ingestions.saved[len(ingestions.saved)-1]
+	if saved.Status != types.IngestionFailed || saved.Error != "interrupted after 1 of 2 files" {
+		t.Errorf("job saved as %s: %q", saved.Status, saved.Error)
+	}
+}
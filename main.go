@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"valyx/aggregator/aa"
+	"valyx/aggregator/aa/mockaa"
+	"valyx/aggregator/aa/mockfip"
 	"valyx/aggregator/utils"
 
 	_ "github.com/lib/pq"
@@ -15,11 +24,38 @@ func init() {
 	viper.SetConfigFile(".env") // Set the path of your .env file
 	viper.ReadInConfig()
 	viper.SetDefault("PORT", "8080")
+	viper.SetDefault("STATEMENT_PROFILES_DIR", "./profiles")
+	viper.SetDefault("AA_BASE_URL", "http://localhost:8090")
+	viper.SetDefault("FIU_ID", "valyx-fiu")
+	viper.SetDefault("MOCK_AA_AUTO_APPROVE", true)
+	viper.SetDefault("MOCK_AA", false)
+	viper.SetDefault("SYNC_INTERVAL", "15m")
+	viper.SetDefault("PURGE_INTERVAL", "1h")
+	viper.SetDefault("ENFORCE_CONSENT", true)
+	viper.SetDefault("CONSENT_EXEMPT_STATEMENTS", false)
+	viper.SetDefault("INSECURE_NOTIFICATIONS", false)
+	viper.SetDefault("FIP_ID", "MOCK-FIP")
+	viper.SetDefault("FIP_READY_AFTER", "2s")
+	viper.SetDefault("ROW_ERROR_POLICY", "reject")
+	viper.SetDefault("WATCH_SETTLE", "2s")
+	viper.SetDefault("INGEST_WORKERS", 4)
 	viper.AutomaticEnv()
 
 }
 
 func main() {
+	switch viper.GetString("MODE") {
+	case "mock-aa":
+		runMockAA()
+		return
+	case "fip":
+		runFIPSimulator()
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	db, err := setupDB()
 	if err != nil {
 		tracerr.Wrap(err)
@@ -29,19 +65,68 @@ func main() {
 	defer db.Close()
 
 	fileProcessor := utils.NewProcessor(db)
-	err = fileProcessor.ReadExcelFiles("./dummyData", db)
+	if err := fileProcessor.LoadProfiles(viper.GetString("STATEMENT_PROFILES_DIR")); err != nil {
+		log.Fatalf("could not load statement profiles: %v", err)
+	}
+	if viper.GetString("ACCOUNT_KEY_SECRET") == "" {
+		log.Println("ACCOUNT_KEY_SECRET is not set: statement accounts are told apart by masked number and IFSC only")
+	}
+	err = fileProcessor.ReadExcelFiles(ctx, "./dummyData", db)
+	if errors.Is(err, context.Canceled) {
+		log.Println("Interrupted while importing ./dummyData, exiting")
+		return
+	}
 	if err != nil {
 		log.Fatalf("could not process files: %v", err)
 	}
 
 	queryService := NewService(db)
 
-	server := NewServer(queryService)
+	var aaVerifier *utils.JWSVerifier
+	if jwksFile := viper.GetString("AA_JWKS_FILE"); jwksFile != "" {
+		if aaVerifier, err = utils.LoadJWKS(jwksFile); err != nil {
+			log.Fatalf("could not load AA public keys: %v", err)
+		}
+	}
+
+	aaHTTPClient, err := newAAHTTPClient(aaVerifier)
+	if err != nil {
+		log.Fatalf("could not set up AA request signing: %v", err)
+	}
+	aaClient := aa.NewClient(viper.GetString("AA_BASE_URL"), viper.GetString("AA_API_KEY"), aaHTTPClient)
+	consentManager := aa.NewConsentManager(aaClient, db, viper.GetString("FIU_ID"), aaVerifier, viper.GetBool("MOCK_AA"))
+	dataFetcher := aa.NewDataFetcher(aaClient)
+
+	server := NewServer(ctx, queryService, consentManager, db, dataFetcher, fileProcessor, db, db, db)
+	if err := db.FailInterruptedIngestions(); err != nil {
+		log.Printf("ingestion: %v", err)
+	}
+	server.resumeFISessions()
+	server.goBackground(func(ctx context.Context) { runScheduler(ctx, server, viper.GetDuration("SYNC_INTERVAL")) })
+	server.goBackground(func(ctx context.Context) { runPurger(ctx, server, viper.GetDuration("PURGE_INTERVAL")) })
+	server.goBackground(func(ctx context.Context) {
+		runWatcher(ctx, server, viper.GetString("WATCH_DIR"), viper.GetDuration("WATCH_SETTLE"))
+	})
+
 	http.HandleFunc("/search", server.SearchHandler)
+	if aaVerifier != nil || viper.GetBool("INSECURE_NOTIFICATIONS") {
+		http.Handle("/Consent/Notification", aaNotificationHandler(server.ConsentNotificationHandler, aaVerifier))
+		http.Handle("/FI/Notification", aaNotificationHandler(server.FINotificationHandler, aaVerifier))
+	} else {
+		log.Println("AA_JWKS_FILE is not set: not accepting AA notifications (set INSECURE_NOTIFICATIONS=true to accept them unsigned)")
+	}
 	http.HandleFunc("/userInfo", server.GetUserInfo)
 	http.HandleFunc("/trend", server.TrendHandler)
 	http.HandleFunc("/aggregate", server.AggregateHandler)
 	http.HandleFunc("/env", server.TestEnvironmentHandler)
+	http.HandleFunc("/consents", server.ConsentsHandler)
+	http.HandleFunc("/consents/", server.ConsentStatusHandler)
+	http.HandleFunc("/syncRuns", server.SyncRunsHandler)
+	http.HandleFunc("/statements", server.StatementsHandler)
+	http.HandleFunc("/ingestions", server.IngestionsHandler)
+	http.HandleFunc("/ingestions/", server.IngestionStatusHandler)
+	http.HandleFunc("/quarantine", server.QuarantineHandler)
+	http.HandleFunc("/quarantine/", server.QuarantineActionHandler)
 
 	serverPort := viper.GetString("PORT")
 	log.Println("Starting server on " + serverPort)
@@ -51,7 +136,111 @@ func main() {
 		Handler: utils.ApplyMiddleware(http.DefaultServeMux, utils.EnableCORS(), utils.LoggingMiddleware),
 	}
 
-	if err := runServer.ListenAndServe(); err != nil {
+	// Once the server has stopped taking requests no more background jobs
+	// can start, so the ones running are waited for before the database is
+	// closed.
+	shutDown := make(chan struct{})
+	go func() {
+		defer close(shutDown)
+		<-ctx.Done()
+		log.Println("Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := runServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("could not shut down server cleanly: %v", err)
+		}
+	}()
+
+	if err := runServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("could not start server: %v", err)
 	}
+	<-shutDown
+	log.Println("Waiting for background jobs to finish")
+	server.jobs.Wait()
+}
+
+// newAAHTTPClient signs AA calls with JWS_PRIVATE_KEY_FILE and verifies the
+// responses with the AA's public keys. Either can be left unset for local runs.
+func newAAHTTPClient(verifier *utils.JWSVerifier) (*http.Client, error) {
+	var signer *utils.JWSSigner
+	if keyFile := viper.GetString("JWS_PRIVATE_KEY_FILE"); keyFile != "" {
+		var err error
+		if signer, err = utils.NewJWSSigner(keyFile, viper.GetString("JWS_KEY_ID")); err != nil {
+			return nil, err
+		}
+	}
+	if signer == nil && verifier == nil {
+		return nil, nil
+	}
+
+	transport := &utils.SigningTransport{Signer: signer, Verifier: verifier}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+// aaNotificationHandler only lets notifications signed by the AA through.
+// Without the AA's public keys they are passed through unchecked, which main
+// only allows when INSECURE_NOTIFICATIONS opts in.
+func aaNotificationHandler(handler http.HandlerFunc, verifier *utils.JWSVerifier) http.Handler {
+	if verifier == nil {
+		return handler
+	}
+	return utils.ApplyMiddleware(handler, utils.VerifyJWS(verifier))
+}
+
+// runMockAA serves the bundled mock Account Aggregator instead of the API,
+// for running the FIU flow locally: MODE=mock-aa PORT=8090.
+func runMockAA() {
+	server := mockaa.NewServer(mockaa.DefaultAccounts(), viper.GetBool("MOCK_AA_AUTO_APPROVE"))
+
+	serverPort := viper.GetString("PORT")
+	log.Println("Starting mock AA on " + serverPort)
+
+	if err := http.ListenAndServe("0.0.0.0:"+serverPort, utils.ApplyMiddleware(server.Handler(), utils.LoggingMiddleware)); err != nil {
+		log.Fatalf("could not start mock AA: %v", err)
+	}
+}
+
+// runFIPSimulator serves the transactions already in the database as a mock
+// FIP behind the mock AA, so AA_BASE_URL can point the FIU at it for the whole
+// consent and FI data flow: MODE=fip PORT=8090 FIP_NOTIFY_URL=http://localhost:8080.
+// Its notifications are signed with JWS_PRIVATE_KEY_FILE, the key the FIU
+// knows the AA by through AA_JWKS_FILE.
+func runFIPSimulator() {
+	db, err := setupDB()
+	if err != nil {
+		log.Fatalf("could not connect to the database: %v", err)
+	}
+	defer db.Close()
+	db.EnforceConsent = false
+
+	var signer *utils.JWSSigner
+	if keyFile := viper.GetString("JWS_PRIVATE_KEY_FILE"); keyFile != "" {
+		if signer, err = utils.NewJWSSigner(keyFile, viper.GetString("JWS_KEY_ID")); err != nil {
+			log.Fatalf("could not load notification signing key: %v", err)
+		}
+	} else if viper.GetString("FIP_NOTIFY_URL") != "" {
+		log.Println("JWS_PRIVATE_KEY_FILE is not set: FI notifications are sent unsigned")
+	}
+
+	aaServer := mockaa.NewServer(mockaa.DefaultAccounts(), viper.GetBool("MOCK_AA_AUTO_APPROVE"))
+	fipServer := mockfip.NewServer(db, mockfip.Options{
+		FIPID:           viper.GetString("FIP_ID"),
+		Latency:         viper.GetDuration("FIP_LATENCY"),
+		ReadyAfter:      viper.GetDuration("FIP_READY_AFTER"),
+		FailureRate:     viper.GetFloat64("FIP_FAILURE_RATE"),
+		NotifyURL:       viper.GetString("FIP_NOTIFY_URL"),
+		Signer:          signer,
+		ConsentAccounts: aaServer.ConsentAccounts,
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/FI/", fipServer.Handler())
+	mux.Handle("/", aaServer.Handler())
+
+	serverPort := viper.GetString("PORT")
+	log.Println("Starting FIP simulator on " + serverPort)
+
+	if err := http.ListenAndServe("0.0.0.0:"+serverPort, utils.ApplyMiddleware(mux, utils.LoggingMiddleware)); err != nil {
+		log.Fatalf("could not start FIP simulator: %v", err)
+	}
 }
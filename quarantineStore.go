@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"valyx/aggregator/types"
+)
+
+func (db *PostgresDB) createQuarantineTable() error {
+	query := `CREATE TABLE IF NOT EXISTS quarantined_files (
+        Id BIGSERIAL PRIMARY KEY,
+        File TEXT NOT NULL,
+        Account_Id TEXT,
+        Mapping JSONB NOT NULL,
+        Records JSONB NOT NULL,
+        First_Line INT NOT NULL,
+        Policy TEXT NOT NULL,
+        Created_At TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    )`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("error creating quarantined_files table: %v", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS quarantined_rows (
+        Id BIGSERIAL PRIMARY KEY,
+        File TEXT NOT NULL,
+        Line INT NOT NULL,
+        Account_Id TEXT,
+        Record JSONB NOT NULL,
+        Reason TEXT NOT NULL,
+        Mapping JSONB NOT NULL,
+        Status TEXT NOT NULL,
+        Created_At TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        Resolved_At TIMESTAMPTZ
+    )`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("error creating quarantined_rows table: %v", err)
+	}
+
+	_, err := db.Exec(`ALTER TABLE quarantined_rows ADD COLUMN IF NOT EXISTS file_id BIGINT REFERENCES quarantined_files (id)`)
+	if err != nil {
+		return fmt.Errorf("error migrating quarantined_rows table: %v", err)
+	}
+	return nil
+}
+
+func (db *PostgresDB) QuarantineFile(file types.QuarantinedFile, rows []types.QuarantinedRow) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error quarantining rows: %v", err)
+	}
+	defer tx.Rollback()
+
+	records, err := json.Marshal(file.Records)
+	if err != nil {
+		return fmt.Errorf("error encoding quarantined file: %v", err)
+	}
+	var fileID int64
+	err = tx.QueryRow(`
+        INSERT INTO quarantined_files (file, account_id, mapping, records, first_line, policy)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id
+    `, file.File, file.AccountID, []byte(file.Mapping), records, file.FirstLine, file.Policy).Scan(&fileID)
+	if err != nil {
+		return fmt.Errorf("error quarantining %s: %v", file.File, err)
+	}
+
+	const query = `
+        INSERT INTO quarantined_rows (file_id, file, line, account_id, record, reason, mapping, status)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `
+	for _, row := range rows {
+		record, err := json.Marshal(row.Record)
+		if err != nil {
+			return fmt.Errorf("error encoding quarantined record: %v", err)
+		}
+		if _, err := tx.Exec(query, fileID, row.File, row.Line, row.AccountID, record, row.Reason, []byte(row.Mapping), types.QuarantinePending); err != nil {
+			return fmt.Errorf("error quarantining line %d of %s: %v", row.Line, row.File, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *PostgresDB) GetQuarantinedFile(id int64) (types.QuarantinedFile, error) {
+	var file types.QuarantinedFile
+	var mapping, records []byte
+	err := db.QueryRow(`
+        SELECT id, file, COALESCE(account_id, ''), mapping, records, first_line, policy, created_at
+        FROM quarantined_files
+        WHERE id = $1
+    `, id).Scan(&file.ID, &file.File, &file.AccountID, &mapping, &records, &file.FirstLine, &file.Policy, &file.CreatedAt)
+	if err == sql.ErrNoRows {
+		return file, fmt.Errorf("quarantined file %d not found", id)
+	}
+	if err != nil {
+		return file, fmt.Errorf("error querying quarantined file %d: %v", id, err)
+	}
+	if err := json.Unmarshal(records, &file.Records); err != nil {
+		return file, fmt.Errorf("error decoding quarantined file %d: %v", id, err)
+	}
+	file.Mapping = mapping
+	return file, nil
+}
+
+func (db *PostgresDB) UpdateQuarantinedFile(file types.QuarantinedFile) error {
+	records, err := json.Marshal(file.Records)
+	if err != nil {
+		return fmt.Errorf("error encoding quarantined file: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE quarantined_files SET records = $2 WHERE id = $1`, file.ID, records); err != nil {
+		return fmt.Errorf("error updating quarantined file %d: %v", file.ID, err)
+	}
+	return nil
+}
+
+const quarantineColumns = `id, COALESCE(file_id, 0), file, line, COALESCE(account_id, ''), record, reason, mapping, status, created_at, resolved_at`
+
+func (db *PostgresDB) GetQuarantinedRow(id int64) (types.QuarantinedRow, error) {
+	row, err := scanQuarantinedRow(db.QueryRow(`SELECT `+quarantineColumns+` FROM quarantined_rows WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return types.QuarantinedRow{}, fmt.Errorf("quarantined row %d not found", id)
+	}
+	return row, err
+}
+
+func (db *PostgresDB) ListQuarantinedRows(status string, limit int) ([]types.QuarantinedRow, error) {
+	query := `SELECT ` + quarantineColumns + `
+        FROM quarantined_rows
+        WHERE ($1 = '' OR status = $1)
+        ORDER BY created_at DESC, id
+        LIMIT $2`
+	rows, err := db.Query(query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying quarantined rows: %v", err)
+	}
+	defer rows.Close()
+
+	var quarantined []types.QuarantinedRow
+	for rows.Next() {
+		row, err := scanQuarantinedRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		quarantined = append(quarantined, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error with rows during quarantine fetching: %v", err)
+	}
+
+	return quarantined, nil
+}
+
+func (db *PostgresDB) FileQuarantinedRows(fileID int64) ([]types.QuarantinedRow, error) {
+	query := `SELECT ` + quarantineColumns + `
+        FROM quarantined_rows
+        WHERE file_id = $1 AND status = $2
+        ORDER BY line`
+	rows, err := db.Query(query, fileID, types.QuarantinePending)
+	if err != nil {
+		return nil, fmt.Errorf("error querying quarantined rows: %v", err)
+	}
+	defer rows.Close()
+
+	var quarantined []types.QuarantinedRow
+	for rows.Next() {
+		row, err := scanQuarantinedRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		quarantined = append(quarantined, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error with rows during quarantine fetching: %v", err)
+	}
+
+	return quarantined, nil
+}
+
+func (db *PostgresDB) UpdateQuarantinedRow(row types.QuarantinedRow) error {
+	record, err := json.Marshal(row.Record)
+	if err != nil {
+		return fmt.Errorf("error encoding quarantined record: %v", err)
+	}
+
+	var resolvedAt *time.Time
+	if row.Status != types.QuarantinePending {
+		now := time.Now()
+		resolvedAt = &now
+	}
+
+	const query = `
+        UPDATE quarantined_rows
+        SET record = $2, reason = $3, status = $4, resolved_at = $5
+        WHERE id = $1
+    `
+	if _, err := db.Exec(query, row.ID, record, row.Reason, row.Status, resolvedAt); err != nil {
+		return fmt.Errorf("error updating quarantined row %d: %v", row.ID, err)
+	}
+	return nil
+}
+
+func scanQuarantinedRow(scanner rowScanner) (types.QuarantinedRow, error) {
+	var row types.QuarantinedRow
+	var record, mapping []byte
+	var resolvedAt sql.NullTime
+	err := scanner.Scan(&row.ID, &row.FileID, &row.File, &row.Line, &row.AccountID, &record, &row.Reason, &mapping, &row.Status, &row.CreatedAt, &resolvedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return row, err
+		}
+		return row, fmt.Errorf("error scanning quarantined row: %v", err)
+	}
+	if err := json.Unmarshal(record, &row.Record); err != nil {
+		return row, fmt.Errorf("error decoding quarantined record: %v", err)
+	}
+	row.Mapping = mapping
+	if resolvedAt.Valid {
+		row.ResolvedAt = &resolvedAt.Time
+	}
+	return row, nil
+}
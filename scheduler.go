@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runScheduler checks every interval which active consents may be fetched
+// again and requests their new data until ctx is cancelled. A zero interval
+// disables it.
+func runScheduler(ctx context.Context, server *Server, interval time.Duration) {
+	if interval <= 0 {
+		log.Println("FI data sync scheduler disabled")
+		return
+	}
+	log.Printf("Syncing consented FI data every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		server.syncDueConsents(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runPurger purges data whose consent lapsed every interval until ctx is
+// cancelled. It runs on its own ticker so the DataLife of consents is
+// honoured even with the sync scheduler disabled.
+func runPurger(ctx context.Context, server *Server, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		server.purgeLapsedData()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
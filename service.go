@@ -2,13 +2,14 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 	"valyx/aggregator/types"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/spf13/viper"
 	"github.com/ztrue/tracerr"
 )
@@ -19,9 +20,15 @@ type Service struct {
 
 type PostgresDB struct {
 	*sql.DB
+	// EnforceConsent limits every transaction query on an account shared
+	// under a consent to the data an active consent covers.
+	EnforceConsent bool
+	// ExemptStatements lets EnforceConsent pass the accounts never shared
+	// under a consent, such as those imported from statements.
+	ExemptStatements bool
 }
 
-func setupDB() (types.DB, error) {
+func setupDB() (*PostgresDB, error) {
 	pg_host := viper.GetString("PGHOST")
 	pg_port := viper.GetString("PGPORT")
 	pg_user := viper.GetString("PGUSER")
@@ -44,7 +51,24 @@ func setupDB() (types.DB, error) {
 		return nil, err
 	}
 
-	return &PostgresDB{DB: db}, nil
+	pg := &PostgresDB{DB: db, EnforceConsent: viper.GetBool("ENFORCE_CONSENT"), ExemptStatements: viper.GetBool("CONSENT_EXEMPT_STATEMENTS")}
+	if err := pg.createSchema(); err != nil {
+		log.Fatal(err)
+		return nil, err
+	}
+
+	return pg, nil
+}
+
+// createSchema creates the tables every query depends on up front, since the
+// consent scope joins them into reads before anything has been written.
+func (db *PostgresDB) createSchema() error {
+	for _, create := range []func() error{db.createTable, db.migrateFingerprints, db.createAccountSummaryTable, db.createConsentTable, db.createConsentAccountTable, db.createSyncRunTable, db.createQuarantineTable, db.createIngestionTable} {
+		if err := create(); err != nil {
+			return fmt.Errorf("error creating tables: %v", err)
+		}
+	}
+	return nil
 }
 
 func NewService(db types.DB) *Service {
@@ -76,7 +100,7 @@ func (s *Service) GetAggregates(category string, startTime time.Time, endTime ti
 }
 
 func (db *PostgresDB) GetUniqueBankAccounts() ([]string, error) {
-	const query = `SELECT DISTINCT account_id FROM transactions`
+	query := `SELECT DISTINCT account_id FROM transactions WHERE 1=1` + db.consentScope()
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("error querying accounts: %v", err)
@@ -100,7 +124,7 @@ func (db *PostgresDB) GetUniqueBankAccounts() ([]string, error) {
 }
 
 func (db *PostgresDB) GetUniqueKeywords() ([]string, error) {
-	const query = `SELECT DISTINCT description FROM transactions`
+	query := `SELECT DISTINCT description FROM transactions WHERE 1=1` + db.consentScope()
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("error querying unique keywords: %v", err)
@@ -130,28 +154,307 @@ func (db *PostgresDB) createTable() error {
         Description TEXT,
         Debit NUMERIC(10, 2),
         Credit NUMERIC(10, 2),
-        Balance NUMERIC(15, 2)
+        Balance NUMERIC(15, 2),
+        External_Id TEXT,
+        Value_Date DATE,
+        Mode TEXT,
+        Reference TEXT,
+        Fingerprint TEXT,
+        Seq INTEGER,
+        Source TEXT
     )`
 	_, err := db.Exec(query)
 	if err != nil {
 		return err
 	}
 
+	_, err = db.Exec(`ALTER TABLE transactions
+        ADD COLUMN IF NOT EXISTS external_id TEXT,
+        ADD COLUMN IF NOT EXISTS value_date DATE,
+        ADD COLUMN IF NOT EXISTS mode TEXT,
+        ADD COLUMN IF NOT EXISTS reference TEXT,
+        ADD COLUMN IF NOT EXISTS fingerprint TEXT,
+        ADD COLUMN IF NOT EXISTS seq INTEGER,
+        ADD COLUMN IF NOT EXISTS source TEXT`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// fingerprintKeySQL builds types.Transaction.FingerprintKey for a stored row.
+const fingerprintKeySQL = `CASE WHEN COALESCE(external_id, '') <> '' THEN account_id || '|id|' || external_id
+        ELSE account_id
+        || '|' || COALESCE(TO_CHAR(date, 'YYYY-MM-DD'), '')
+        || '|' || (COALESCE(credit, 0) - COALESCE(debit, 0))::NUMERIC(15, 2)::TEXT
+        || '|' || COALESCE(balance::NUMERIC(15, 2)::TEXT, '')
+        || '|' || LOWER(BTRIM(REGEXP_REPLACE(COALESCE(description, ''), '\s+', ' ', 'g')))
+        END`
+
+// migrateFingerprints fingerprints rows stored before transactions had one and
+// adds the unique index upserts rely on. Rows duplicated by earlier restarts
+// are dropped first; a row with a running balance cannot genuinely repeat, so
+// only those are treated as duplicates. Rows with a bank transaction ID that
+// were fingerprinted by their description are keyed by the ID instead, keeping
+// one row per ID.
+func (db *PostgresDB) migrateFingerprints() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        DELETE FROM transactions t
+        USING (
+            SELECT ctid, ROW_NUMBER() OVER (PARTITION BY ` + fingerprintKeySQL + ` ORDER BY ctid) - 1 AS ordinal
+            FROM transactions
+            WHERE fingerprint IS NULL
+        ) n
+        WHERE t.ctid = n.ctid AND n.ordinal > 0 AND t.balance IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("error removing duplicate transactions: %v", err)
+	}
+
+	_, err = tx.Exec(`
+        UPDATE transactions t
+        SET fingerprint = MD5(n.key || '|' || n.ordinal)
+        FROM (
+            SELECT ctid, key, ROW_NUMBER() OVER (PARTITION BY key ORDER BY ctid) - 1 AS ordinal
+            FROM (SELECT ctid, ` + fingerprintKeySQL + ` AS key FROM transactions WHERE fingerprint IS NULL) keyed
+        ) n
+        WHERE t.ctid = n.ctid`)
+	if err != nil {
+		return fmt.Errorf("error fingerprinting transactions: %v", err)
+	}
+
+	_, err = tx.Exec(`
+        DELETE FROM transactions t
+        USING transactions keep
+        WHERE COALESCE(t.external_id, '') <> ''
+            AND keep.account_id = t.account_id AND keep.external_id = t.external_id AND keep.ctid < t.ctid`)
+	if err != nil {
+		return fmt.Errorf("error removing duplicate transactions: %v", err)
+	}
+
+	_, err = tx.Exec(`
+        UPDATE transactions
+        SET fingerprint = MD5(account_id || '|id|' || external_id || '|0')
+        WHERE COALESCE(external_id, '') <> '' AND fingerprint IS DISTINCT FROM MD5(account_id || '|id|' || external_id || '|0')`)
+	if err != nil {
+		return fmt.Errorf("error fingerprinting transactions: %v", err)
+	}
+
+	_, err = tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS transactions_fingerprint_idx ON transactions (fingerprint)`)
+	if err != nil {
+		return fmt.Errorf("error creating fingerprint index: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// InsertBatch stores one file's account summaries and transactions in a single
+// database transaction. Transactions are streamed with COPY into a staging
+// table and upserted by fingerprint from there, so a bad row rolls back the
+// whole file and re-imports still never duplicate.
+func (db *PostgresDB) InsertBatch(batch types.Batch) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting batch: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, s := range batch.Accounts {
+		if err := upsertAccountSummary(tx, s); err != nil {
+			return 0, err
+		}
+	}
+
+	var inserted int
+	if len(batch.Transactions) > 0 {
+		if inserted, err = copyTransactions(tx, batch.Transactions); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing batch: %v", err)
+	}
+	return inserted, nil
+}
+
+func copyTransactions(tx *sql.Tx, transactions []types.Transaction) (int, error) {
+	_, err := tx.Exec(`CREATE TEMP TABLE transactions_staging (LIKE transactions INCLUDING DEFAULTS) ON COMMIT DROP`)
+	if err != nil {
+		return 0, fmt.Errorf("error creating staging table: %v", err)
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("transactions_staging",
+		"account_id", "date", "description", "debit", "credit", "balance", "external_id", "value_date", "mode", "reference", "fingerprint", "seq", "source"))
+	if err != nil {
+		return 0, fmt.Errorf("error starting copy: %v", err)
+	}
+
+	for i, t := range transactions {
+		if t.Fingerprint == "" {
+			t.Fingerprint = types.Fingerprint(t.FingerprintKey(), 0)
+		}
+		_, err := stmt.Exec(t.AccountID, t.Date, t.Description, t.Debit, t.Credit, t.Balance,
+			nullIfEmpty(t.ExternalID), nullIfEmpty(t.ValueDate), nullIfEmpty(t.Mode), nullIfEmpty(t.Reference), t.Fingerprint, t.Seq, nullIfEmpty(t.Source))
+		if err != nil {
+			stmt.Close()
+			return 0, fmt.Errorf("error copying transaction %d: %v", i+1, err)
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return 0, fmt.Errorf("error copying transactions: %v", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, fmt.Errorf("error copying transactions: %v", err)
+	}
+
+	// xmax is zero only for rows this statement inserted, not those it updated.
+	var inserted int
+	err = tx.QueryRow(`
+        WITH upserted AS (
+            INSERT INTO transactions (account_id, date, description, debit, credit, balance, external_id, value_date, mode, reference, fingerprint, seq, source)
+            SELECT DISTINCT ON (fingerprint) account_id, date, description, debit, credit, balance, external_id, value_date, mode, reference, fingerprint, seq, source
+            FROM transactions_staging
+            ORDER BY fingerprint
+            ON CONFLICT (fingerprint) DO UPDATE SET
+                external_id = COALESCE(EXCLUDED.external_id, transactions.external_id),
+                value_date = COALESCE(EXCLUDED.value_date, transactions.value_date),
+                mode = COALESCE(EXCLUDED.mode, transactions.mode),
+                reference = COALESCE(EXCLUDED.reference, transactions.reference),
+                seq = COALESCE(transactions.seq, EXCLUDED.seq),
+                source = COALESCE(transactions.source, EXCLUDED.source)
+            RETURNING xmax = 0 AS inserted
+        )
+        SELECT COUNT(*) FILTER (WHERE inserted) FROM upserted
+    `).Scan(&inserted)
+	if err != nil {
+		return 0, fmt.Errorf("error upserting copied transactions: %v", err)
+	}
+	return inserted, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (db *PostgresDB) createAccountSummaryTable() error {
+	query := `CREATE TABLE IF NOT EXISTS account_summaries (
+        Account_Id TEXT PRIMARY KEY,
+        Masked_Acc_Number TEXT,
+        Linked_Acc_Ref TEXT,
+        FI_Type TEXT,
+        Account_Type TEXT,
+        Holding_Type TEXT,
+        Holders JSONB,
+        Current_Balance NUMERIC(15, 2),
+        Currency TEXT,
+        Balance_Date_Time TIMESTAMPTZ,
+        Branch TEXT,
+        IFSC TEXT,
+        MICR TEXT,
+        Opening_Date TEXT,
+        Status TEXT,
+        Updated_At TIMESTAMPTZ DEFAULT NOW()
+    )`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	// Balance times were kept as text, which does not order a date against
+	// a timestamp of the same day.
+	_, err := db.Exec(`
+        DO $$ BEGIN
+            IF (SELECT data_type FROM information_schema.columns
+                WHERE table_name = 'account_summaries' AND column_name = 'balance_date_time') = 'text' THEN
+                ALTER TABLE account_summaries ALTER COLUMN balance_date_time TYPE TIMESTAMPTZ
+                    USING CASE WHEN balance_date_time ~ '^\d{4}-\d{2}-\d{2}$'
+                        THEN (balance_date_time || ' 23:59:59+05:30')::TIMESTAMPTZ
+                        ELSE NULLIF(balance_date_time, '')::TIMESTAMPTZ END;
+            END IF;
+        END $$`)
+	if err != nil {
+		return fmt.Errorf("error migrating account_summaries table: %v", err)
+	}
 	return nil
 }
 
-func (db *PostgresDB) InsertTransaction(t types.Transaction) error {
-	if err := db.createTable(); err != nil {
-		return fmt.Errorf("error creating transactions table: %v", err)
+// ist is the zone statement dates are in.
+var ist = time.FixedZone("IST", 5*60*60+30*60)
+
+// balanceTime reads the time a summary's balance is as of. A date alone, as
+// statements give, is taken as the end of that day.
+func balanceTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05", value, ist); err == nil {
+		return &t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, ist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid balance time %q", value)
+	}
+	end := day.Add(24*time.Hour - time.Second)
+	return &end, nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// upsertAccountSummary stores s unless the account already has a summary as
+// of a later time, so importing an older statement after a newer one keeps
+// the newer balance. A summary without a balance time is older than any.
+func upsertAccountSummary(db execer, s types.AccountSummary) error {
+	holders, err := json.Marshal(s.Holders)
+	if err != nil {
+		return fmt.Errorf("error encoding account holders: %v", err)
+	}
+	asOf, err := balanceTime(s.BalanceDateTime)
+	if err != nil {
+		return fmt.Errorf("error upserting account summary %s: %v", s.AccountID, err)
 	}
 
 	const query = `
-        INSERT INTO transactions (account_id, date, description, debit, credit, balance)
-        VALUES ($1, $2, $3, $4, $5, $6)
+        INSERT INTO account_summaries (account_id, masked_acc_number, linked_acc_ref, fi_type, account_type, holding_type,
+            holders, current_balance, currency, balance_date_time, branch, ifsc, micr, opening_date, status, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, NOW())
+        ON CONFLICT (account_id) DO UPDATE SET
+            masked_acc_number = EXCLUDED.masked_acc_number,
+            linked_acc_ref = EXCLUDED.linked_acc_ref,
+            fi_type = EXCLUDED.fi_type,
+            account_type = EXCLUDED.account_type,
+            holding_type = EXCLUDED.holding_type,
+            holders = EXCLUDED.holders,
+            current_balance = EXCLUDED.current_balance,
+            currency = EXCLUDED.currency,
+            balance_date_time = EXCLUDED.balance_date_time,
+            branch = EXCLUDED.branch,
+            ifsc = EXCLUDED.ifsc,
+            micr = EXCLUDED.micr,
+            opening_date = EXCLUDED.opening_date,
+            status = EXCLUDED.status,
+            updated_at = NOW()
+        WHERE COALESCE(EXCLUDED.balance_date_time, '-infinity') >= COALESCE(account_summaries.balance_date_time, '-infinity')
     `
-	_, err := db.Exec(query, t.AccountID, t.Date, t.Description, t.Debit, t.Credit, t.Balance)
+	_, err = db.Exec(query, s.AccountID, s.MaskedAccNumber, s.LinkedAccRef, s.FIType, s.AccountType, s.HoldingType,
+		holders, s.CurrentBalance, s.Currency, asOf, s.Branch, s.IFSC, s.MICR, s.OpeningDate, s.Status)
 	if err != nil {
-		return fmt.Errorf("error inserting transaction: %v", err)
+		return fmt.Errorf("error upserting account summary: %v", err)
 	}
 	return nil
 }
@@ -163,6 +466,7 @@ func (db *PostgresDB) QueryTransactions(keyword string, accounts []string, start
         FROM transactions
         WHERE description ILIKE $1
     `)
+	query.WriteString(db.consentScope())
 	params := []interface{}{"%" + keyword + "%"}
 	paramID := 2
 
@@ -190,6 +494,8 @@ func (db *PostgresDB) QueryTransactions(keyword string, accounts []string, start
 		params = append(params, endTime)
 	}
 
+	query.WriteString(" ORDER BY date, seq")
+
 	rows, err := db.Query(query.String(), params...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying transactions: %v", err)
@@ -222,6 +528,7 @@ func (db *PostgresDB) QueryTransactionsWithPagination(keyword string, accounts [
 
 	var queryBuilder strings.Builder
 	queryBuilder.WriteString("SELECT account_id, date, description, debit, credit, balance FROM transactions WHERE 1=1")
+	queryBuilder.WriteString(db.consentScope())
 
 	var params []interface{}
 	paramID := 1
@@ -251,7 +558,7 @@ func (db *PostgresDB) QueryTransactionsWithPagination(keyword string, accounts [
 		paramID++
 	}
 
-	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY date %s LIMIT $%d OFFSET $%d", sortOrder, paramID, paramID+1))
+	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY date %s, seq %s LIMIT $%d OFFSET $%d", sortOrder, sortOrder, paramID, paramID+1))
 	params = append(params, limit, offset)
 
 	rows, err := db.Query(queryBuilder.String(), params...)
@@ -301,6 +608,7 @@ func (db *PostgresDB) GetTrendData(category string, startTime, endTime time.Time
         FROM transactions
         WHERE description ILIKE $1
     `)
+	queryBuilder.WriteString(db.consentScope())
 
 	params := []interface{}{"%" + category + "%"}
 	paramIndex := 2
@@ -348,6 +656,7 @@ func (db *PostgresDB) GetAggregateData(category string, startTime, endTime time.
         FROM transactions
         WHERE description ILIKE $1
     `)
+	queryBuilder.WriteString(db.consentScope())
 
 	params := []interface{}{"%" + category + "%"}
 	paramIndex := 2
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBalanceTime(t *testing.T) {
+	ordered := []string{
+		"2023-08-31T10:00:00+05:30",
+		"2023-08-31T12:00:00",
+		"2023-08-31",
+		"2023-08-31T20:00:00Z",
+	}
+	var previous string
+	for i, value := range ordered {
+		asOf, err := balanceTime(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			before, _ := balanceTime(previous)
+			if !asOf.After(*before) {
+				t.Errorf("%s is not after %s", value, previous)
+			}
+		}
+		previous = value
+	}
+
+	if asOf, err := balanceTime(""); asOf != nil || err != nil {
+		t.Errorf("empty balance time = %v, %v", asOf, err)
+	}
+	if _, err := balanceTime("31/08/2023"); err == nil {
+		t.Error("31/08/2023 accepted")
+	}
+}
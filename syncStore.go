@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"valyx/aggregator/types"
+
+	"github.com/lib/pq"
+)
+
+func (db *PostgresDB) createSyncRunTable() error {
+	query := `CREATE TABLE IF NOT EXISTS sync_runs (
+        Id BIGSERIAL PRIMARY KEY,
+        Consent_Id TEXT NOT NULL,
+        Session_Id TEXT UNIQUE,
+        Status TEXT NOT NULL,
+        Data_From TIMESTAMPTZ NOT NULL,
+        Data_To TIMESTAMPTZ NOT NULL,
+        Transactions INT NOT NULL DEFAULT 0,
+        Error TEXT,
+        Started_At TIMESTAMPTZ NOT NULL,
+        Finished_At TIMESTAMPTZ
+    )`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("error creating sync_runs table: %v", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS sync_runs_consent_idx ON sync_runs (consent_id, started_at DESC)`); err != nil {
+		return fmt.Errorf("error creating sync_runs index: %v", err)
+	}
+
+	// The session key is only kept while the run waits on its session.
+	_, err := db.Exec(`ALTER TABLE sync_runs
+        ADD COLUMN IF NOT EXISTS private_key TEXT,
+        ADD COLUMN IF NOT EXISTS key_nonce TEXT`)
+	if err != nil {
+		return fmt.Errorf("error migrating sync_runs table: %v", err)
+	}
+	return nil
+}
+
+const syncRunColumns = `id, consent_id, COALESCE(session_id, ''), status, data_from, data_to, transactions, COALESCE(error, ''), started_at, finished_at`
+
+func (db *PostgresDB) CreateSyncRun(run *types.SyncRun) error {
+	const query = `
+        INSERT INTO sync_runs (consent_id, session_id, status, data_from, data_to, transactions, error, started_at, finished_at)
+        VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, NULLIF($7, ''), $8, $9)
+        RETURNING id
+    `
+	err := db.QueryRow(query, run.ConsentID, run.SessionID, run.Status, run.DataFrom, run.DataTo, run.Transactions, run.Error, run.StartedAt, run.FinishedAt).Scan(&run.ID)
+	if err != nil {
+		return fmt.Errorf("error creating sync run: %v", err)
+	}
+	return nil
+}
+
+func (db *PostgresDB) UpdateSyncRun(run types.SyncRun) error {
+	const query = `
+        UPDATE sync_runs
+        SET session_id = NULLIF($2, ''), status = $3, transactions = $4, error = NULLIF($5, ''), finished_at = $6,
+            private_key = CASE WHEN $3 = $9 THEN NULLIF($7, '') END,
+            key_nonce = CASE WHEN $3 = $9 THEN NULLIF($8, '') END
+        WHERE id = $1
+    `
+	_, err := db.Exec(query, run.ID, run.SessionID, run.Status, run.Transactions, run.Error, run.FinishedAt, run.PrivateKey, run.Nonce, types.SyncRequested)
+	if err != nil {
+		return fmt.Errorf("error updating sync run %d: %v", run.ID, err)
+	}
+	return nil
+}
+
+func (db *PostgresDB) GetSyncRunBySession(sessionID string) (types.SyncRun, error) {
+	query := `SELECT ` + syncRunColumns + ` FROM sync_runs WHERE session_id = $1`
+	run, err := scanSyncRun(db.QueryRow(query, sessionID))
+	if err == sql.ErrNoRows {
+		return types.SyncRun{}, fmt.Errorf("no sync run for session %s", sessionID)
+	}
+	return run, err
+}
+
+func (db *PostgresDB) LastSyncRun(consentID string, statuses ...string) (*types.SyncRun, error) {
+	query := `SELECT ` + syncRunColumns + `
+        FROM sync_runs
+        WHERE consent_id = $1 AND status = ANY($2)
+        ORDER BY started_at DESC
+        LIMIT 1`
+	run, err := scanSyncRun(db.QueryRow(query, consentID, pq.Array(statuses)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (db *PostgresDB) CountSyncRuns(consentID string, statuses ...string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sync_runs WHERE consent_id = $1 AND status = ANY($2)`, consentID, pq.Array(statuses)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting sync runs: %v", err)
+	}
+	return count, nil
+}
+
+func (db *PostgresDB) ListSyncRuns(consentID string, limit int) ([]types.SyncRun, error) {
+	query := `SELECT ` + syncRunColumns + `
+        FROM sync_runs
+        WHERE ($1 = '' OR consent_id = $1)
+        ORDER BY started_at DESC
+        LIMIT $2`
+	rows, err := db.Query(query, consentID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying sync runs: %v", err)
+	}
+	defer rows.Close()
+
+	var runs []types.SyncRun
+	for rows.Next() {
+		run, err := scanSyncRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error with rows during sync run fetching: %v", err)
+	}
+
+	return runs, nil
+}
+
+func (db *PostgresDB) ExpireSyncRuns(before time.Time) error {
+	const query = `
+        UPDATE sync_runs
+        SET status = $1, error = 'FI session expired before the data was fetched', finished_at = NOW(),
+            private_key = NULL, key_nonce = NULL
+        WHERE status = $2 AND started_at < $3
+    `
+	if _, err := db.Exec(query, types.SyncFailed, types.SyncRequested, before); err != nil {
+		return fmt.Errorf("error expiring sync runs: %v", err)
+	}
+	return nil
+}
+
+func (db *PostgresDB) PendingSyncRuns() ([]types.SyncRun, error) {
+	query := `SELECT ` + syncRunColumns + `, COALESCE(private_key, ''), COALESCE(key_nonce, '')
+        FROM sync_runs
+        WHERE status = $1 AND session_id IS NOT NULL
+        ORDER BY started_at`
+	rows, err := db.Query(query, types.SyncRequested)
+	if err != nil {
+		return nil, fmt.Errorf("error querying pending sync runs: %v", err)
+	}
+	defer rows.Close()
+
+	var runs []types.SyncRun
+	for rows.Next() {
+		var run types.SyncRun
+		var finishedAt sql.NullTime
+		err := rows.Scan(&run.ID, &run.ConsentID, &run.SessionID, &run.Status, &run.DataFrom, &run.DataTo, &run.Transactions, &run.Error, &run.StartedAt, &finishedAt, &run.PrivateKey, &run.Nonce)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning sync run: %v", err)
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error with rows during sync run fetching: %v", err)
+	}
+
+	return runs, nil
+}
+
+func scanSyncRun(row rowScanner) (types.SyncRun, error) {
+	var run types.SyncRun
+	var finishedAt sql.NullTime
+	err := row.Scan(&run.ID, &run.ConsentID, &run.SessionID, &run.Status, &run.DataFrom, &run.DataTo, &run.Transactions, &run.Error, &run.StartedAt, &finishedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return run, err
+		}
+		return run, fmt.Errorf("error scanning sync run: %v", err)
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return run, nil
+}
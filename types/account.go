@@ -0,0 +1,32 @@
+package types
+
+type AccountHolder struct {
+	Name           string `json:"name"`
+	DOB            string `json:"dob,omitempty"`
+	Mobile         string `json:"mobile,omitempty"`
+	Email          string `json:"email,omitempty"`
+	PAN            string `json:"pan,omitempty"`
+	Address        string `json:"address,omitempty"`
+	Nominee        string `json:"nominee,omitempty"`
+	CKYCCompliance bool   `json:"ckycCompliance"`
+}
+
+// AccountSummary is the account level data an FIP shares alongside the
+// transactions of a deposit account.
+type AccountSummary struct {
+	AccountID       string          `json:"accountId"`
+	MaskedAccNumber string          `json:"maskedAccNumber"`
+	LinkedAccRef    string          `json:"linkedAccRef,omitempty"`
+	FIType          string          `json:"fiType"`
+	AccountType     string          `json:"accountType"`
+	HoldingType     string          `json:"holdingType,omitempty"`
+	Holders         []AccountHolder `json:"holders"`
+	CurrentBalance  float64         `json:"currentBalance"`
+	Currency        string          `json:"currency"`
+	BalanceDateTime string          `json:"balanceDateTime,omitempty"`
+	Branch          string          `json:"branch,omitempty"`
+	IFSC            string          `json:"ifsc,omitempty"`
+	MICR            string          `json:"micr,omitempty"`
+	OpeningDate     string          `json:"openingDate,omitempty"`
+	Status          string          `json:"status,omitempty"`
+}
@@ -0,0 +1,102 @@
+package types
+
+import "time"
+
+// Consent lifecycle states. PENDING and FAILED describe the consent request
+// before an artefact exists, the rest mirror the ReBIT consent status.
+const (
+	ConsentPending  = "PENDING"
+	ConsentFailed   = "FAILED"
+	ConsentRejected = "REJECTED"
+	ConsentActive   = "ACTIVE"
+	ConsentPaused   = "PAUSED"
+	ConsentRevoked  = "REVOKED"
+	ConsentExpired  = "EXPIRED"
+)
+
+type ConsentDetail struct {
+	ConsentStart  string           `json:"consentStart"`
+	ConsentExpiry string           `json:"consentExpiry"`
+	ConsentMode   string           `json:"consentMode"`
+	FetchType     string           `json:"fetchType"`
+	ConsentTypes  []string         `json:"consentTypes"`
+	FITypes       []string         `json:"fiTypes"`
+	DataConsumer  ConsentEntity    `json:"DataConsumer"`
+	DataProvider  *ConsentEntity   `json:"DataProvider,omitempty"`
+	Customer      ConsentCustomer  `json:"Customer"`
+	Accounts      []ConsentAccount `json:"Accounts,omitempty"`
+	Purpose       ConsentPurpose   `json:"Purpose"`
+	FIDataRange   DateRange        `json:"FIDataRange"`
+	DataLife      ConsentPeriod    `json:"DataLife"`
+	Frequency     ConsentPeriod    `json:"Frequency"`
+	DataFilter    []DataFilter     `json:"DataFilter,omitempty"`
+}
+
+type ConsentEntity struct {
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"`
+}
+
+type ConsentCustomer struct {
+	ID string `json:"id"`
+}
+
+type ConsentAccount struct {
+	FIType          string `json:"fiType"`
+	FIPID           string `json:"fipId"`
+	AccType         string `json:"accType"`
+	LinkRefNumber   string `json:"linkRefNumber"`
+	MaskedAccNumber string `json:"maskedAccNumber"`
+}
+
+type ConsentPurpose struct {
+	Code     string          `json:"code"`
+	RefURI   string          `json:"refUri"`
+	Text     string          `json:"text"`
+	Category PurposeCategory `json:"Category"`
+}
+
+type PurposeCategory struct {
+	Type string `json:"type"`
+}
+
+type DateRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ConsentPeriod is used for both DataLife (unit DAY/MONTH/YEAR/INF) and
+// Frequency (unit HOUR/DAY/MONTH/YEAR, value is the number of fetches).
+type ConsentPeriod struct {
+	Unit  string `json:"unit"`
+	Value int    `json:"value"`
+}
+
+type DataFilter struct {
+	Type     string `json:"type"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// Consent is the FIU side record of a consent request, keyed by the consent
+// handle the AA returns and completed with the consent ID and signed artefact
+// once the customer approves.
+type Consent struct {
+	ConsentHandle string        `json:"consentHandle"`
+	ConsentID     string        `json:"consentId,omitempty"`
+	Status        string        `json:"status"`
+	Detail        ConsentDetail `json:"consentDetail"`
+	SignedConsent string        `json:"signedConsent,omitempty"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	UpdatedAt     time.Time     `json:"updatedAt"`
+}
+
+type ConsentStore interface {
+	SaveConsent(c Consent) error
+	GetConsent(consentHandle string) (Consent, error)
+	GetConsentByID(consentID string) (Consent, error)
+	ListConsents() ([]Consent, error)
+	// PurgeLapsedData deletes data no longer covered by a consent we may
+	// retain and returns the number of transactions removed.
+	PurgeLapsedData() (int64, error)
+}
@@ -2,11 +2,20 @@ package types
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 )
 
+// ErrNotFound is wrapped by store errors for records that do not exist.
+var ErrNotFound = errors.New("not found")
+
 type DB interface {
-	InsertTransaction(t Transaction) error
+	// InsertBatch stores everything one file contributes atomically and
+	// returns how many of its transactions were not already stored.
+	InsertBatch(b Batch) (int, error)
+	// QuarantineFile keeps a file that had rows fail together with those
+	// rows.
+	QuarantineFile(file QuarantinedFile, rows []QuarantinedRow) error
 	QueryTransactions(keyword string, accounts []string, startTime, endTime time.Time) ([]Transaction, error)
 	GetUniqueKeywords() ([]string, error)
 	GetUniqueBankAccounts() ([]string, error)
@@ -18,11 +27,28 @@ type DB interface {
 
 type Transaction struct {
 	Date        string
+	ValueDate   string
 	Description string
 	Debit       sql.NullFloat64
 	Credit      sql.NullFloat64
 	Balance     sql.NullFloat64
 	AccountID   string
+	ExternalID  string
+	Mode        string
+	Reference   string
+	Fingerprint string
+	// Seq is the row's place among its account's rows in the statement,
+	// oldest first, so that rows of the same day keep their order.
+	Seq int
+	// Source is the statement file the row was first imported from, naming
+	// the archive or email it was extracted from as the quarantine does.
+	Source string
+}
+
+// Batch is what one statement file yields.
+type Batch struct {
+	Accounts     []AccountSummary
+	Transactions []Transaction
 }
 
 type TrendData struct {
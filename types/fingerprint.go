@@ -0,0 +1,53 @@
+package types
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// FingerprintKey normalises what identifies a transaction on a statement:
+// the account and the bank's own transaction ID (an OFX FITID or ReBIT
+// txnId) when it has one, else account, date, signed amount, balance and
+// description. Transactions that share a key are told apart by their ordinal
+// in Fingerprint.
+//
+// The same key is built in SQL when fingerprinting existing rows, see
+// fingerprintKeySQL in service.go; keep the two in step.
+func (t Transaction) FingerprintKey() string {
+	if t.ExternalID != "" {
+		return t.AccountID + "|id|" + t.ExternalID
+	}
+
+	date := t.Date
+	if len(date) > 10 {
+		date = date[:10]
+	}
+
+	amount := t.Credit.Float64 - t.Debit.Float64
+	if amount == 0 {
+		amount = 0 // no negative zero
+	}
+
+	balance := ""
+	if t.Balance.Valid {
+		balance = strconv.FormatFloat(t.Balance.Float64, 'f', 2, 64)
+	}
+
+	return strings.Join([]string{
+		t.AccountID,
+		date,
+		strconv.FormatFloat(amount, 'f', 2, 64),
+		balance,
+		strings.ToLower(strings.Join(strings.Fields(t.Description), " ")),
+	}, "|")
+}
+
+// Fingerprint identifies the ordinal-th (from 0) transaction with key within
+// one statement, so re-importing the same or an overlapping statement yields
+// the same fingerprints while genuinely repeated rows stay distinct.
+func Fingerprint(key string, ordinal int) string {
+	sum := md5.Sum([]byte(key + "|" + strconv.Itoa(ordinal)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,89 @@
+package types
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestFingerprintKey(t *testing.T) {
+	debit := func(amount float64) sql.NullFloat64 { return sql.NullFloat64{Float64: amount, Valid: true} }
+	base := Transaction{AccountID: "hdfc", Date: "2023-08-05", Description: "NEFT  Rent", Debit: debit(100), Balance: debit(900)}
+
+	tests := []struct {
+		name string
+		a, b func(t *Transaction)
+		same bool
+	}{
+		{
+			name: "whitespace and case of the description",
+			a:    func(t *Transaction) {},
+			b:    func(t *Transaction) { t.Description = " neft rent " },
+			same: true,
+		},
+		{
+			name: "time of day",
+			a:    func(t *Transaction) {},
+			b:    func(t *Transaction) { t.Date = "2023-08-05 10:30:00" },
+			same: true,
+		},
+		{
+			name: "different amount",
+			a:    func(t *Transaction) {},
+			b:    func(t *Transaction) { t.Debit = debit(101) },
+			same: false,
+		},
+		{
+			name: "different account",
+			a:    func(t *Transaction) {},
+			b:    func(t *Transaction) { t.AccountID = "icici" },
+			same: false,
+		},
+		{
+			name: "same FITID with a different memo",
+			a:    func(t *Transaction) { t.ExternalID = "20230805001" },
+			b:    func(t *Transaction) { t.ExternalID = "20230805001"; t.Description = "NEFT RENT AUGUST" },
+			same: true,
+		},
+		{
+			name: "different FITIDs with the same details",
+			a:    func(t *Transaction) { t.ExternalID = "20230805001" },
+			b:    func(t *Transaction) { t.ExternalID = "20230805002" },
+			same: false,
+		},
+		{
+			name: "same FITID on another account",
+			a:    func(t *Transaction) { t.ExternalID = "1" },
+			b:    func(t *Transaction) { t.ExternalID = "1"; t.AccountID = "icici" },
+			same: false,
+		},
+	}
+
+	for _, test := range tests {
+		a, b := base, base
+		test.a(&a)
+		test.b(&b)
+		if same := a.FingerprintKey() == b.FingerprintKey(); same != test.same {
+			t.Errorf("%s: keys %q and %q, want same=%v", test.name, a.FingerprintKey(), b.FingerprintKey(), test.same)
+		}
+	}
+}
+
+func TestFingerprintKeyNegativeZero(t *testing.T) {
+	zero := Transaction{AccountID: "hdfc", Date: "2023-08-05", Debit: sql.NullFloat64{Valid: true}}
+	if key := zero.FingerprintKey(); key != "hdfc|2023-08-05|0.00||" {
+		t.Errorf("key = %q", key)
+	}
+}
+
+func TestFingerprintOrdinal(t *testing.T) {
+	if Fingerprint("k", 0) == Fingerprint("k", 1) {
+		t.Error("repeated rows share a fingerprint")
+	}
+	if Fingerprint("k", 0) != Fingerprint("k", 0) {
+		t.Error("fingerprint is not stable")
+	}
+	// MD5("k|0"), as migrateFingerprints computes it in SQL.
+	if got := Fingerprint("k", 0); got != "f16bff9aa34e1199a8ebe0248c8d7db8" {
+		t.Errorf("fingerprint = %q", got)
+	}
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Ingestion job states. A job is QUEUED until a worker picks it up and
+// SUCCEEDED once every file was read, even if some rows were quarantined.
+const (
+	IngestionQueued    = "QUEUED"
+	IngestionRunning   = "RUNNING"
+	IngestionSucceeded = "SUCCEEDED"
+	IngestionFailed    = "FAILED"
+)
+
+// IngestionStatuses lists every ingestion job state.
+var IngestionStatuses = []string{IngestionQueued, IngestionRunning, IngestionSucceeded, IngestionFailed}
+
+// IngestionJob tracks the background import of a set of uploaded statements.
+// The counters grow as files are read, Files too as archives and emails are
+// unpacked into their statements; Reports holds the per-file outcome,
+// including the account each file was stored under. AccountID is only set
+// when the upload named the account.
+type IngestionJob struct {
+	ID         int64           `json:"id"`
+	AccountID  string          `json:"accountId,omitempty"`
+	Profile    string          `json:"profile,omitempty"`
+	Status     string          `json:"status"`
+	Files      int             `json:"files"`
+	FilesDone  int             `json:"filesDone"`
+	Rows       int             `json:"rows"`
+	Inserted   int             `json:"inserted"`
+	Duplicates int             `json:"duplicates"`
+	Rejected   int             `json:"rejected"`
+	Reports    json.RawMessage `json:"reports,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+type IngestionStore interface {
+	CreateIngestion(job *IngestionJob) error
+	UpdateIngestion(job IngestionJob) error
+	GetIngestion(id int64) (IngestionJob, error)
+	ListIngestions(status string, limit int) ([]IngestionJob, error)
+	// FailInterruptedIngestions fails the jobs left queued or running by a
+	// previous process, whose uploads did not survive it.
+	FailInterruptedIngestions() error
+}
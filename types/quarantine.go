@@ -0,0 +1,65 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Quarantined row states.
+const (
+	QuarantinePending     = "PENDING"
+	QuarantineResubmitted = "RESUBMITTED"
+	QuarantineDiscarded   = "DISCARDED"
+)
+
+// QuarantineStatuses lists every quarantined row state.
+var QuarantineStatuses = []string{QuarantinePending, QuarantineResubmitted, QuarantineDiscarded}
+
+// QuarantinedRow is a statement row that failed to parse, kept with the column
+// mapping of its file so it can be fixed and parsed again.
+type QuarantinedRow struct {
+	ID int64 `json:"id"`
+	// FileID is the quarantined file the row belongs to, zero for rows
+	// quarantined before whole files were kept.
+	FileID int64 `json:"fileId,omitempty"`
+	// File names the row's file as the import report does, including the
+	// archive or email it was extracted from, like the source of the
+	// transactions stored from it.
+	File       string          `json:"file"`
+	Line       int             `json:"line"`
+	AccountID  string          `json:"accountId"`
+	Record     []string        `json:"record"`
+	Reason     string          `json:"reason"`
+	Mapping    json.RawMessage `json:"mapping"`
+	Status     string          `json:"status"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	ResolvedAt *time.Time      `json:"resolvedAt,omitempty"`
+}
+
+// QuarantinedFile holds every data row of a file that had rows quarantined,
+// so the file can be imported again as a whole once they are fixed. Records
+// keeps blank rows, and a discarded row as nil, so every row keeps the place
+// it had in the file: Records[i] is line FirstLine+i.
+type QuarantinedFile struct {
+	ID        int64
+	File      string
+	AccountID string
+	Mapping   json.RawMessage
+	Records   [][]string
+	FirstLine int
+	// Policy is the ROW_ERROR_POLICY the file was imported under.
+	Policy    string
+	CreatedAt time.Time
+}
+
+type QuarantineStore interface {
+	GetQuarantinedRow(id int64) (QuarantinedRow, error)
+	ListQuarantinedRows(status string, limit int) ([]QuarantinedRow, error)
+	// UpdateQuarantinedRow saves a row's record, reason and status.
+	UpdateQuarantinedRow(row QuarantinedRow) error
+	GetQuarantinedFile(id int64) (QuarantinedFile, error)
+	// FileQuarantinedRows returns the rows of the file still pending.
+	FileQuarantinedRows(fileID int64) ([]QuarantinedRow, error)
+	// UpdateQuarantinedFile saves the file's records.
+	UpdateQuarantinedFile(file QuarantinedFile) error
+}
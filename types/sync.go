@@ -0,0 +1,47 @@
+package types
+
+import "time"
+
+// Sync run states. A run is REQUESTED once the AA accepted the FI request and
+// COMPLETED or FAILED once its session has been fetched or given up on.
+const (
+	SyncRequested = "REQUESTED"
+	SyncCompleted = "COMPLETED"
+	SyncFailed    = "FAILED"
+)
+
+// SyncRun records one FI data fetch under a consent.
+type SyncRun struct {
+	ID           int64      `json:"id"`
+	ConsentID    string     `json:"consentId"`
+	SessionID    string     `json:"sessionId,omitempty"`
+	Status       string     `json:"status"`
+	DataFrom     time.Time  `json:"dataFrom"`
+	DataTo       time.Time  `json:"dataTo"`
+	Transactions int        `json:"transactions"`
+	Error        string     `json:"error,omitempty"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+
+	// PrivateKey and Nonce are our exported key pair of a REQUESTED run's
+	// session, kept so the data can still be decrypted after a restart.
+	PrivateKey string `json:"-"`
+	Nonce      string `json:"-"`
+}
+
+type SyncStore interface {
+	CreateSyncRun(run *SyncRun) error
+	UpdateSyncRun(run SyncRun) error
+	GetSyncRunBySession(sessionID string) (SyncRun, error)
+	// LastSyncRun returns the latest run of the consent in one of statuses,
+	// or nil if there is none.
+	LastSyncRun(consentID string, statuses ...string) (*SyncRun, error)
+	ListSyncRuns(consentID string, limit int) ([]SyncRun, error)
+	// CountSyncRuns counts the runs of the consent in one of statuses.
+	CountSyncRuns(consentID string, statuses ...string) (int, error)
+	// ExpireSyncRuns fails the runs still waiting on a session started before.
+	ExpireSyncRuns(before time.Time) error
+	// PendingSyncRuns returns the runs still waiting on their session, with
+	// the session's key.
+	PendingSyncRuns() ([]SyncRun, error)
+}
@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"valyx/aggregator/types"
+
+	"github.com/spf13/viper"
+)
+
+// StatementAccount identifies the account a statement belongs to, as printed
+// in the statement's header block or supplied alongside the file. The full
+// account number never leaves the importer: rows are stored under its key,
+// which is Masked with a suffix telling apart accounts that share it.
+type StatementAccount struct {
+	Number     string `json:"-"`
+	Masked     string `json:"maskedAccNumber,omitempty"`
+	Holder     string `json:"holder,omitempty"`
+	IFSC       string `json:"ifsc,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	PeriodFrom string `json:"periodFrom,omitempty"`
+	PeriodTo   string `json:"periodTo,omitempty"`
+}
+
+var (
+	accountNumberField = regexp.MustCompile(`(?i)\b(?:a/c|account)\.?\s*(?:no|num|number)?\.?\s*(?:[:#|-]\s*)*([0-9Xx*][0-9Xx* -]{2,28}[0-9])`)
+	holderField        = regexp.MustCompile(`(?i)(?:^|\|)\s*(?:account\s+holder(?:\s+name)?|account\s+name|customer\s+name|name)\s*[:|-]\s*([^|]+)`)
+	branchField        = regexp.MustCompile(`(?i)(?:^|\|)\s*branch(?:\s+name)?\s*[:|-]\s*([^|]+)`)
+	ifscCode           = regexp.MustCompile(`\b([A-Z]{4}0[A-Z0-9]{6})\b`)
+	periodField        = regexp.MustCompile(`(?i)\b(?:period|from)\b`)
+	bannerDate         = regexp.MustCompile(`\b\d{1,4}[-/. ](?:\d{1,2}|[A-Za-z]{3,9})[-/. ]\d{2,4}\b`)
+)
+
+// readBanner picks the account details out of the banner rows above a
+// statement's header. Cells are joined with " | " so that a label and its
+// value in neighbouring cells read like "Account No : 1234".
+func readBanner(rows [][]string) StatementAccount {
+	var account StatementAccount
+	for _, row := range rows {
+		var cells []string
+		for _, cell := range row {
+			if cell = strings.Join(strings.Fields(cell), " "); cell != "" {
+				cells = append(cells, cell)
+			}
+		}
+		line := strings.Join(cells, " | ")
+
+		if m := accountNumberField.FindStringSubmatch(line); m != nil && account.Number == "" {
+			if number := normaliseAccountNumber(m[1]); countDigits(number) >= 4 {
+				account.Number = number
+			}
+		}
+		if m := holderField.FindStringSubmatch(line); m != nil && account.Holder == "" {
+			account.Holder = strings.TrimSpace(m[1])
+		}
+		if m := branchField.FindStringSubmatch(line); m != nil && account.Branch == "" {
+			account.Branch = strings.TrimSpace(m[1])
+		}
+		if m := ifscCode.FindStringSubmatch(line); m != nil && account.IFSC == "" {
+			account.IFSC = m[1]
+		}
+		if periodField.MatchString(line) && account.PeriodFrom == "" {
+			var dates []string
+			for _, value := range bannerDate.FindAllString(line, -1) {
+				if date, err := ParseDate(value); err == nil {
+					dates = append(dates, date.Format("2006-01-02"))
+				}
+			}
+			if len(dates) >= 2 {
+				account.PeriodFrom, account.PeriodTo = dates[0], dates[1]
+			}
+		}
+	}
+	return account
+}
+
+// merge fills the fields a leaves empty from b.
+func (a StatementAccount) merge(b StatementAccount) StatementAccount {
+	fill(&a.Number, b.Number)
+	fill(&a.Holder, b.Holder)
+	fill(&a.IFSC, b.IFSC)
+	fill(&a.Branch, b.Branch)
+	fill(&a.PeriodFrom, b.PeriodFrom)
+	fill(&a.PeriodTo, b.PeriodTo)
+	a.Number = normaliseAccountNumber(a.Number)
+	a.Masked = MaskAccountNumber(a.Number)
+	return a
+}
+
+func fill(dst *string, src string) {
+	if *dst == "" {
+		*dst = src
+	}
+}
+
+func (a StatementAccount) empty() bool {
+	return a == StatementAccount{}
+}
+
+// summary is the account summary a statement gives for its account, taking
+// the balance of its latest row as the current balance.
+func (a StatementAccount) summary(accountId string, transactions []types.Transaction) types.AccountSummary {
+	summary := types.AccountSummary{
+		AccountID:       accountId,
+		MaskedAccNumber: a.Masked,
+		FIType:          "DEPOSIT",
+		Currency:        "INR",
+		Branch:          a.Branch,
+		IFSC:            a.IFSC,
+	}
+	if a.Holder != "" {
+		summary.Holders = []types.AccountHolder{{Name: a.Holder}}
+	}
+	if latest := latestBalance(transactions); latest != nil {
+		summary.CurrentBalance = latest.Balance.Float64
+		summary.BalanceDateTime = latest.Date
+	}
+	return summary
+}
+
+// latestBalance returns the last row by date that carries a balance, in
+// whichever order the statement lists its rows, or nil if none does.
+func latestBalance(transactions []types.Transaction) *types.Transaction {
+	if len(transactions) == 0 {
+		return nil
+	}
+	oldestFirst := transactions[0].Date <= transactions[len(transactions)-1].Date
+
+	var latest *types.Transaction
+	for i := range transactions {
+		t := &transactions[i]
+		if !t.Balance.Valid {
+			continue
+		}
+		if latest == nil || t.Date > latest.Date || (t.Date == latest.Date && oldestFirst) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// key is the ID an account's rows are stored under: its masked number
+// followed by a short HMAC of the full number under ACCOUNT_KEY_SECRET, so
+// that accounts sharing their last four digits stay apart without the number
+// being recoverable from the key. Without the secret, or for a number the
+// statement prints masked, the IFSC follows instead, which only tells apart
+// accounts of different branches. It is empty without a number.
+func (a StatementAccount) key() string {
+	if a.Number == "" {
+		return ""
+	}
+	masked := MaskAccountNumber(a.Number)
+	if secret := viper.GetString("ACCOUNT_KEY_SECRET"); secret != "" && !strings.Contains(a.Number, "X") {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(a.Number))
+		return masked + "-" + hex.EncodeToString(mac.Sum(nil)[:4])
+	}
+	if a.IFSC != "" {
+		return masked + "-" + a.IFSC
+	}
+	return masked
+}
+
+// keyAccounts moves the accounts a parser names to their keys, so that the
+// numbers OFX, MT940 and camt files carry are stored no more than those read
+// from a header block. ReBIT data is keyed by its masked number.
+func (s *Statement) keyAccounts() {
+	keys := make(map[string]string)
+	for i := range s.Accounts {
+		summary := &s.Accounts[i]
+		number := summary.MaskedAccNumber
+		if number == "" {
+			number = summary.AccountID
+		}
+		account := StatementAccount{Number: number, IFSC: summary.IFSC}.merge(StatementAccount{})
+		if key := account.key(); key != "" {
+			keys[summary.AccountID] = key
+			summary.AccountID = key
+			summary.MaskedAccNumber = account.Masked
+		}
+	}
+	for i := range s.Transactions {
+		t := &s.Transactions[i]
+		key, ok := keys[t.AccountID]
+		if !ok {
+			key = StatementAccount{Number: t.AccountID}.merge(StatementAccount{}).key()
+			keys[t.AccountID] = key
+		}
+		if key != "" {
+			t.AccountID = key
+		}
+	}
+}
+
+// accountID picks the account ID of a file: the one the task gives, else the
+// key of the account number from the statement or the supplied metadata,
+// else the file name without its extension.
+func (t IngestTask) accountID(account StatementAccount) string {
+	if t.AccountID != "" {
+		return t.AccountID
+	}
+	if key := account.key(); key != "" {
+		return key
+	}
+	name := filepath.Base(t.Path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// MaskAccountNumber hides all but the last four digits of an account number,
+// e.g. 50100123451234 becomes XXXXXXXXXX1234. Numbers the statement already
+// prints masked keep their visible digits.
+func MaskAccountNumber(number string) string {
+	number = normaliseAccountNumber(number)
+	if len(number) <= 4 {
+		return number
+	}
+	return strings.Repeat("X", len(number)-4) + number[len(number)-4:]
+}
+
+// normaliseAccountNumber drops the spaces and dashes statements group account
+// numbers with and writes every masking character as X.
+func normaliseAccountNumber(number string) string {
+	return strings.NewReplacer(" ", "", "-", "", "*", "X", "x", "X").Replace(strings.TrimSpace(number))
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
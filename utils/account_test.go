@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// statementFor is a statement whose header block prints the account number,
+// with one row on date leaving balance.
+func statementFor(number, date, balance string) string {
+	return "HDFC BANK LTD,,,,\n" +
+		"Account Name : RAHUL SHARMA,,,,\n" +
+		"Account No," + number + ",,,\n" +
+		"Branch : KORAMANGALA,IFSC : HDFC0001234,,,\n" +
+		",,,,\n" +
+		"Date,Description,Debit,Credit,Balance\n" +
+		date + ",ATM,100.00,," + balance + "\n"
+}
+
+func TestReadBanner(t *testing.T) {
+	got := readBanner([][]string{
+		{"HDFC BANK LTD"},
+		{"Account Name : RAHUL SHARMA"},
+		{"Account No", "5010 0123 451234"},
+		{"Branch : KORAMANGALA", "IFSC : HDFC0001234"},
+		{"Statement From : 01/08/2023 To : 31/08/2023"},
+	})
+	want := StatementAccount{
+		Number:     "50100123451234",
+		Holder:     "RAHUL SHARMA",
+		IFSC:       "HDFC0001234",
+		Branch:     "KORAMANGALA",
+		PeriodFrom: "2023-08-01",
+		PeriodTo:   "2023-08-31",
+	}
+	if got != want {
+		t.Errorf("readBanner = %+v, want %+v", got, want)
+	}
+}
+
+func TestAccountsSharingLastDigitsStayApart(t *testing.T) {
+	viper.Set("ACCOUNT_KEY_SECRET", "test secret")
+	t.Cleanup(func() { viper.Set("ACCOUNT_KEY_SECRET", "") })
+	db := newMemDB()
+	p := NewProcessor(db)
+
+	ingest := func(name, number string) *IngestReport {
+		t.Helper()
+		report, err := p.IngestFile(context.Background(), IngestTask{Path: writeStatement(t, name, statementFor(number, "05/08/2023", "900.00"))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	first := ingest("first.csv", "50100123451234")
+	second := ingest("second.csv", "99900011121234")
+	again := ingest("again.csv", "5010-0123-451234")
+
+	if first.AccountID == second.AccountID {
+		t.Errorf("both accounts stored as %s", first.AccountID)
+	}
+	if again.AccountID != first.AccountID {
+		t.Errorf("same account stored as %s and %s", first.AccountID, again.AccountID)
+	}
+	for _, report := range []*IngestReport{first, second} {
+		summary := db.summaries[report.AccountID]
+		if !strings.HasPrefix(report.AccountID, "XXXXXXXXXX1234-") || strings.Contains(report.AccountID, "HDFC") ||
+			summary.MaskedAccNumber != "XXXXXXXXXX1234" {
+			t.Errorf("account %s shown as %q", report.AccountID, summary.MaskedAccNumber)
+		}
+	}
+
+	// A masked number, or any number without the secret, only tells
+	// accounts apart by branch.
+	masked := StatementAccount{Number: "XXXXXXXXXX1234", IFSC: "HDFC0001234"}
+	other := StatementAccount{Number: "XXXXXXXXXX1234", IFSC: "ICIC0000001"}
+	if masked.key() != "XXXXXXXXXX1234-HDFC0001234" || other.key() != "XXXXXXXXXX1234-ICIC0000001" {
+		t.Errorf("masked numbers keyed as %s and %s", masked.key(), other.key())
+	}
+	viper.Set("ACCOUNT_KEY_SECRET", "")
+	full := StatementAccount{Number: "50100123451234", IFSC: "HDFC0001234"}
+	if key := full.key(); key != "XXXXXXXXXX1234-HDFC0001234" {
+		t.Errorf("without the secret keyed as %s", key)
+	}
+}
+
+func TestOlderStatementKeepsNewerSummary(t *testing.T) {
+	db := newMemDB()
+	p := NewProcessor(db)
+
+	for _, statement := range []string{
+		statementFor("50100123451234", "31/08/2023", "900.00"),
+		statementFor("50100123451234", "31/07/2023", "1000.00"),
+	} {
+		if _, err := p.IngestFile(context.Background(), IngestTask{Path: writeStatement(t, "hdfc.csv", statement)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, summary := range db.summaries {
+		if summary.CurrentBalance != 900 || summary.BalanceDateTime != "2023-08-31" {
+			t.Errorf("summary = %+v", summary)
+		}
+	}
+}
+
+func TestKeyLocks(t *testing.T) {
+	var locks KeyLocks
+	unlock := locks.Lock("a", "b", "a")
+
+	acquired := make(chan string, 2)
+	go func() {
+		locks.Lock("b")()
+		acquired <- "b"
+	}()
+	go func() {
+		locks.Lock("c")()
+		acquired <- "c"
+	}()
+
+	if got := <-acquired; got != "c" {
+		t.Fatalf("%s locked while held", got)
+	}
+	select {
+	case got := <-acquired:
+		t.Fatalf("%s locked while held", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	if got := <-acquired; got != "b" {
+		t.Fatalf("got %s", got)
+	}
+	if len(locks.locks) != 0 {
+		t.Errorf("%d locks left", len(locks.locks))
+	}
+}
+
+func TestParsedAccountsAreKeyed(t *testing.T) {
+	db := newMemDB()
+	p := NewProcessor(db)
+
+	for name, content := range map[string]string{
+		"hdfc.sta": ":20:S1\n:25:50100123451234\n:61:230805C10,NTRFNONREF//B1\n",
+		"rebit.json": `{"account": {"maskedAccNumber": "XXXXXXXXXX5678", "linkedAccRef": "ref-1", "Summary": {"ifscCode": "HDFC0001234"},
+			"Transactions": {"Transaction": [{"type": "DEBIT", "amount": "1", "valueDate": "2023-08-01"}]}}}`,
+	} {
+		if _, err := p.IngestFile(context.Background(), IngestTask{Path: writeStatement(t, name, content)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var accounts []string
+	for _, txn := range db.txns {
+		accounts = append(accounts, txn.AccountID)
+	}
+	sort.Strings(accounts)
+	if !reflect.DeepEqual(accounts, []string{"XXXXXXXXXX1234", "XXXXXXXXXX5678-HDFC0001234"}) {
+		t.Errorf("rows stored under %q", accounts)
+	}
+	if summary, ok := db.summaries["XXXXXXXXXX5678-HDFC0001234"]; !ok || summary.LinkedAccRef != "ref-1" {
+		t.Errorf("summaries = %+v", db.summaries)
+	}
+}
+
+func TestParsedFileAccountID(t *testing.T) {
+	const statement = ":20:S1\n:25:50100123451234\n:61:230805C10,NTRFNONREF//B1\n-\n:20:S2\n:25:99900011125678\n:61:230806C2,NTRFNONREF//B2\n"
+
+	for _, test := range []struct {
+		accountId string
+		want      string
+	}{
+		{"", "XXXXXXXXXX1234,XXXXXXXXXX5678"},
+		{"hdfc", "hdfc"},
+	} {
+		db := newMemDB()
+		p := NewProcessor(db)
+		report, err := p.IngestFile(context.Background(), IngestTask{Path: writeStatement(t, "hdfc.sta", statement), AccountID: test.accountId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts := make(map[string]bool)
+		for _, txn := range db.txns {
+			accounts[txn.AccountID] = true
+		}
+		var stored []string
+		for account := range accounts {
+			stored = append(stored, account)
+		}
+		sort.Strings(stored)
+		if report.AccountID != test.want || strings.Join(stored, ",") != test.want {
+			t.Errorf("accountId %q: report %q, rows stored under %q", test.accountId, report.AccountID, stored)
+		}
+	}
+}
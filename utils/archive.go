@@ -0,0 +1,390 @@
+package utils
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of container Expand unpacks. A Maildir is a directory with cur and
+// new subdirectories holding one message per file.
+const (
+	zipArchive = ".zip"
+	mbox       = ".mbox"
+	email      = ".eml"
+	maildir    = "maildir"
+)
+
+// Limits on what Expand unpacks, against zip bombs and archives that hold
+// archives that hold archives. The member and size budgets cover everything
+// one call extracts, nested containers included.
+const (
+	maxMemberSize    = 64 << 20
+	maxArchiveDepth  = 3
+	maxExpandMembers = 1000
+	maxExpandedSize  = 512 << 20
+)
+
+// sourceSeparator joins a container's name and the member or message a file
+// was extracted from.
+const sourceSeparator = " > "
+
+// IsContainer reports whether path is a zip archive, mailbox, email or
+// Maildir whose statements Expand extracts.
+func IsContainer(path string) bool {
+	return containerKind(path) != ""
+}
+
+func containerKind(path string) string {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case zipArchive, mbox, email:
+		return ext
+	}
+	for _, sub := range []string{"cur", "new"} {
+		if info, err := os.Stat(filepath.Join(path, sub)); err != nil || !info.IsDir() {
+			return ""
+		}
+	}
+	return maildir
+}
+
+// Expand unpacks a zip archive, mbox file, email or Maildir into the
+// statement files it holds, written under dir, and returns a task for each
+// that inherits task's account and profile. The Source of each names the
+// archive member or message ID the file came from. Tasks for other files are
+// returned as they are. Members that cannot be read are reported in the error
+// while the rest are still returned.
+func (p *Processor) Expand(task IngestTask, dir string) ([]IngestTask, error) {
+	return p.expand(task, dir, 0, &expandBudget{root: task.source()})
+}
+
+// expandBudget counts what one Expand has extracted so far.
+type expandBudget struct {
+	root    string
+	members int
+	size    int64
+	// spent is set once the budget has run out and that was reported.
+	spent bool
+}
+
+func (p *Processor) expand(task IngestTask, dir string, depth int, budget *expandBudget) ([]IngestTask, error) {
+	kind := containerKind(task.Path)
+	if kind == "" {
+		return []IngestTask{task}, nil
+	}
+	if depth >= maxArchiveDepth {
+		return nil, fmt.Errorf("%s is nested more than %d archives deep", task.source(), maxArchiveDepth)
+	}
+
+	x := &extraction{p: p, dir: dir, parent: task, depth: depth, budget: budget}
+	var err error
+	switch kind {
+	case zipArchive:
+		err = x.zip()
+	case mbox:
+		err = x.mbox()
+	case email:
+		err = x.email()
+	case maildir:
+		err = x.maildir()
+	}
+	if err == nil && len(x.tasks) == 0 {
+		err = fmt.Errorf("no statements found in %s", task.source())
+	}
+	return x.tasks, err
+}
+
+// source names where a task's file came from, the file name for files not
+// extracted from a container.
+func (t IngestTask) source() string {
+	if t.Source != "" {
+		return t.Source
+	}
+	return filepath.Base(t.Path)
+}
+
+// extraction collects the statements unpacked from one container.
+type extraction struct {
+	p      *Processor
+	dir    string
+	parent IngestTask
+	depth  int
+	budget *expandBudget
+	tasks  []IngestTask
+}
+
+func (x *extraction) zip() error {
+	archive, err := zip.OpenReader(x.parent.Path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", x.parent.source(), err)
+	}
+	defer archive.Close()
+
+	var errs []error
+	for _, member := range archive.File {
+		// Skip folders and the resource forks macOS adds to archives.
+		if member.FileInfo().IsDir() || strings.HasPrefix(member.Name, "__MACOSX/") || strings.HasPrefix(path.Base(member.Name), "._") {
+			continue
+		}
+		if !x.wanted(member.Name) {
+			continue
+		}
+		source := x.parent.source() + sourceSeparator + member.Name
+		if member.UncompressedSize64 > maxMemberSize {
+			errs = append(errs, fmt.Errorf("%s is larger than %d bytes", source, maxMemberSize))
+			continue
+		}
+		r, err := member.Open()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading %s: %v", source, err))
+			continue
+		}
+		err = x.add(member.Name, source, r)
+		r.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mbox splits a mailbox at its "From " lines, undoing the ">From " quoting
+// of body lines, and reads each message.
+func (x *extraction) mbox() error {
+	f, err := os.Open(x.parent.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var errs []error
+	var message bytes.Buffer
+	n := 0
+	flush := func() {
+		if message.Len() == 0 {
+			return
+		}
+		n++
+		if err := x.message(message.Bytes(), fmt.Sprintf("message %d", n)); err != nil {
+			errs = append(errs, err)
+		}
+		message.Reset()
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		switch {
+		case bytes.HasPrefix(line, []byte("From ")):
+			flush()
+		case bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")):
+			message.Write(line[1:])
+		default:
+			message.Write(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", x.parent.source(), err)
+		}
+	}
+	flush()
+	return errors.Join(errs...)
+}
+
+func (x *extraction) email() error {
+	data, err := os.ReadFile(x.parent.Path)
+	if err != nil {
+		return err
+	}
+	return x.message(data, "")
+}
+
+// maildir reads the delivered messages of a Maildir in name order.
+func (x *extraction) maildir() error {
+	var errs []error
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(x.parent.Path, sub))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(x.parent.Path, sub, entry.Name()))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := x.message(data, entry.Name()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// message extracts the statement attachments of an email, identified by its
+// Message-ID or else by fallbackID, if any.
+func (x *extraction) message(data []byte, fallbackID string) error {
+	source := x.parent.source()
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		if fallbackID != "" {
+			source += sourceSeparator + fallbackID
+		}
+		return fmt.Errorf("error reading %s: %v", source, err)
+	}
+	if id := strings.TrimSpace(msg.Header.Get("Message-Id")); id != "" {
+		source += sourceSeparator + id
+	} else if fallbackID != "" {
+		source += sourceSeparator + fallbackID
+	}
+	return x.part(textproto.MIMEHeader(msg.Header), msg.Body, source)
+}
+
+// part walks a MIME part, descending into multiparts and forwarded messages,
+// and adds the statement files attached to it.
+func (x *extraction) part(header textproto.MIMEHeader, body io.Reader, source string) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		var errs []error
+		for {
+			part, err := parts.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error reading %s: %v", source, err))
+				break
+			}
+			if err := x.part(part.Header, part, source); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	name := attachmentName(header, params)
+	if name == "" {
+		if mediaType != "message/rfc822" {
+			return nil
+		}
+		data, err := io.ReadAll(io.LimitReader(body, maxMemberSize))
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", source, err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("error reading message forwarded in %s: %v", source, err)
+		}
+		if id := strings.TrimSpace(msg.Header.Get("Message-Id")); id != "" {
+			source += sourceSeparator + id
+		}
+		return x.part(textproto.MIMEHeader(msg.Header), msg.Body, source)
+	}
+
+	if !x.wanted(name) {
+		return nil
+	}
+	return x.add(name, source+sourceSeparator+name, body)
+}
+
+// attachmentName is the file name a MIME part is attached under, from its
+// Content-Disposition or else its Content-Type.
+func attachmentName(header textproto.MIMEHeader, typeParams map[string]string) string {
+	name := typeParams["name"]
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	return strings.TrimSpace(name)
+}
+
+// wanted reports whether a member or attachment is a statement or a
+// container that may hold some.
+func (x *extraction) wanted(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return x.p.Supports(ext)
+}
+
+// add writes a member or attachment to its own folder under dir, keeping its
+// base name for profiles to match on, and queues it or what it contains.
+// Once the budget of the Expand is spent nothing more is written, and only
+// the member that ran it out is reported.
+func (x *extraction) add(name, source string, r io.Reader) error {
+	budget := x.budget
+	if budget.spent {
+		return nil
+	}
+	if budget.members >= maxExpandMembers {
+		budget.spent = true
+		return fmt.Errorf("%s holds more than %d files, not extracting %s or any after it", budget.root, maxExpandMembers, source)
+	}
+	limit := int64(maxMemberSize)
+	if left := maxExpandedSize - budget.size; left < limit {
+		limit = left
+	}
+
+	folder, err := os.MkdirTemp(x.dir, "member-")
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(folder, path.Base(strings.ReplaceAll(name, `\`, "/")))
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	budget.members++
+	budget.size += n
+	if err != nil {
+		return fmt.Errorf("error extracting %s: %v", source, err)
+	}
+	if n > limit {
+		os.RemoveAll(folder)
+		if limit < maxMemberSize {
+			budget.spent = true
+			return fmt.Errorf("%s unpacks to more than %d bytes, not extracting %s or any after it", budget.root, maxExpandedSize, source)
+		}
+		return fmt.Errorf("%s is larger than %d bytes", source, maxMemberSize)
+	}
+
+	task := x.parent
+	task.Path, task.Source = target, source
+	tasks, err := x.p.expand(task, x.dir, x.depth+1, budget)
+	x.tasks = append(x.tasks, tasks...)
+	return err
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const memberStatement = "Date,Description,Debit,Credit,Balance\n05/08/2023,ATM,100.00,,900.00\n"
+
+// writeZip writes an archive holding count statements, or the members given.
+func writeZip(t *testing.T, name string, count int, members map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	if members == nil {
+		members = make(map[string]string)
+		for i := 0; i < count; i++ {
+			members[fmt.Sprintf("stmt%04d.csv", i)] = memberStatement
+		}
+	}
+	for name, content := range members {
+		member, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		member.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExpandMemberBudget(t *testing.T) {
+	p := NewProcessor(newMemDB())
+	archive := writeZip(t, "bundle.zip", maxExpandMembers+5, nil)
+
+	tasks, err := p.Expand(IngestTask{Path: archive}, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "more than 1000 files") {
+		t.Fatalf("err = %v", err)
+	}
+	if len(tasks) != maxExpandMembers {
+		t.Errorf("extracted %d statements", len(tasks))
+	}
+}
+
+func TestExpandSizeBudget(t *testing.T) {
+	p := NewProcessor(newMemDB())
+	archive := writeZip(t, "bundle.zip", 0, map[string]string{"a.csv": memberStatement})
+
+	// The budget is shared with the containers around this one.
+	budget := &expandBudget{root: "outer.zip", size: maxExpandedSize - 10}
+	tasks, err := p.expand(IngestTask{Path: archive}, t.TempDir(), 0, budget)
+	if err == nil || !strings.Contains(err.Error(), "outer.zip unpacks to more than") {
+		t.Fatalf("err = %v", err)
+	}
+	if len(tasks) != 0 || !budget.spent {
+		t.Errorf("extracted %d statements, budget %+v", len(tasks), budget)
+	}
+}
+
+func TestExpandedRowsKeepSource(t *testing.T) {
+	db := newMemDB()
+	p := NewProcessor(db)
+	archive := writeZip(t, "bundle.zip", 0, map[string]string{"aug/hdfc.csv": memberStatement})
+
+	tasks, err := p.Expand(IngestTask{Path: archive, AccountID: "hdfc"}, t.TempDir())
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Expand = %d tasks, %v", len(tasks), err)
+	}
+	report, err := p.IngestFile(context.Background(), tasks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.File != "bundle.zip > aug/hdfc.csv" || len(db.txns) != 1 {
+		t.Fatalf("report %+v, %d rows stored", report, len(db.txns))
+	}
+	for _, txn := range db.txns {
+		if txn.Source != report.File {
+			t.Errorf("source = %q", txn.Source)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"database/sql"
+	"math"
+
+	"valyx/aggregator/types"
+)
+
+// balanceTolerance allows a paisa either way, since some exports round the
+// amount and the balance separately.
+const balanceTolerance = 0.015
+
+// maxReorderRows bounds the same-day rows we try to put back in order.
+const maxReorderRows = 64
+
+// Kinds of balance break.
+const (
+	// BreakGap means rows are missing between the two balances, or an amount
+	// is wrong.
+	BreakGap = "gap"
+	// BreakSign means the balance follows if the row's debit and credit are
+	// swapped.
+	BreakSign = "sign"
+)
+
+// BalanceBreak is a row whose balance does not follow from the one before.
+type BalanceBreak struct {
+	AccountID   string  `json:"accountId"`
+	Date        string  `json:"date"`
+	Description string  `json:"description"`
+	Kind        string  `json:"kind"`
+	Expected    float64 `json:"expected"`
+	Actual      float64 `json:"actual"`
+}
+
+// BalanceCheck is the result of checking the running balance of a file.
+type BalanceCheck struct {
+	// Checked counts the rows that carry a balance.
+	Checked int `json:"checked"`
+	// Reordered counts the same-day rows moved to restore continuity.
+	Reordered int            `json:"reordered"`
+	Breaks    []BalanceBreak `json:"breaks,omitempty"`
+}
+
+// checkBalances verifies for each account that previous balance - debit +
+// credit gives the balance of every row. Where a day's rows only chain in
+// another order they are reordered in place, and each row's Seq records its
+// place in the corrected order so that it is stored. It returns nil when no
+// row carries a balance.
+func checkBalances(transactions []types.Transaction) *BalanceCheck {
+	byAccount := make(map[string][]int)
+	var accounts []string
+	for i, t := range transactions {
+		if _, ok := byAccount[t.AccountID]; !ok {
+			accounts = append(accounts, t.AccountID)
+		}
+		byAccount[t.AccountID] = append(byAccount[t.AccountID], i)
+	}
+
+	check := &BalanceCheck{}
+	for _, accountId := range accounts {
+		indexes := byAccount[accountId]
+		rows := make([]types.Transaction, len(indexes))
+		for j, i := range indexes {
+			rows[j] = transactions[i]
+		}
+		check.account(accountId, rows)
+		for j, i := range indexes {
+			transactions[i] = rows[j]
+		}
+	}
+
+	if check.Checked == 0 {
+		return nil
+	}
+	return check
+}
+
+// account checks and numbers the rows of one account, oldest first whichever
+// way the statement lists them.
+func (c *BalanceCheck) account(accountId string, rows []types.Transaction) {
+	if len(rows) > 1 && rows[0].Date > rows[len(rows)-1].Date {
+		reverse(rows)
+		defer reverse(rows)
+	}
+
+	var balance sql.NullFloat64
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for end < len(rows) && rows[end].Date == rows[start].Date {
+			end++
+		}
+		day := rows[start:end]
+
+		if !chains(balance, day) && !chains(sql.NullFloat64{}, day) {
+			if order := chainOrder(balance, day); order != nil {
+				reordered := make([]types.Transaction, len(day))
+				for k, j := range order {
+					reordered[k] = day[j]
+					if k != j {
+						c.Reordered++
+					}
+				}
+				copy(day, reordered)
+			}
+		}
+
+		for _, t := range day {
+			balance = c.step(accountId, balance, t)
+		}
+		start = end
+	}
+
+	for k := range rows {
+		rows[k].Seq = k
+	}
+}
+
+// step checks t against the running balance and returns the balance after it.
+func (c *BalanceCheck) step(accountId string, balance sql.NullFloat64, t types.Transaction) sql.NullFloat64 {
+	if !t.Balance.Valid {
+		return after(balance, t)
+	}
+	c.Checked++
+
+	if !follows(balance, t) {
+		kind := BreakGap
+		if math.Abs(balance.Float64-net(t)-t.Balance.Float64) < balanceTolerance {
+			kind = BreakSign
+		}
+		c.Breaks = append(c.Breaks, BalanceBreak{
+			AccountID:   accountId,
+			Date:        t.Date,
+			Description: t.Description,
+			Kind:        kind,
+			Expected:    math.Round((balance.Float64+net(t))*100) / 100,
+			Actual:      t.Balance.Float64,
+		})
+	}
+	return t.Balance
+}
+
+// chainOrder looks for an order of a day's rows in which every balance
+// follows from the one before, first continuing from balance and then from
+// each row in turn. It returns nil if there is none.
+func chainOrder(balance sql.NullFloat64, day []types.Transaction) []int {
+	if len(day) > maxReorderRows {
+		return nil
+	}
+	for _, t := range day {
+		if !t.Balance.Valid {
+			return nil
+		}
+	}
+
+	if balance.Valid {
+		if order := greedyChain(balance, day, nil); order != nil {
+			return order
+		}
+	}
+	for first := range day {
+		if order := greedyChain(day[first].Balance, day, []int{first}); order != nil {
+			return order
+		}
+	}
+	return nil
+}
+
+// greedyChain extends order with whichever unused row follows the balance so
+// far until every row is used, or returns nil when it gets stuck.
+func greedyChain(balance sql.NullFloat64, day []types.Transaction, order []int) []int {
+	used := make([]bool, len(day))
+	for _, j := range order {
+		used[j] = true
+	}
+
+	for len(order) < len(day) {
+		next := -1
+		for j, t := range day {
+			if !used[j] && follows(balance, t) {
+				next = j
+				break
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+		used[next] = true
+		order = append(order, next)
+		balance = day[next].Balance
+	}
+	return order
+}
+
+// chains reports whether every row of day follows from the one before,
+// starting from balance.
+func chains(balance sql.NullFloat64, day []types.Transaction) bool {
+	for _, t := range day {
+		if !follows(balance, t) {
+			return false
+		}
+		balance = after(balance, t)
+	}
+	return true
+}
+
+// follows reports whether t's balance follows from balance. Rows are taken
+// to follow when either balance is unknown.
+func follows(balance sql.NullFloat64, t types.Transaction) bool {
+	if !balance.Valid || !t.Balance.Valid {
+		return true
+	}
+	return math.Abs(balance.Float64+net(t)-t.Balance.Float64) < balanceTolerance
+}
+
+func after(balance sql.NullFloat64, t types.Transaction) sql.NullFloat64 {
+	if t.Balance.Valid {
+		return t.Balance
+	}
+	if balance.Valid {
+		balance.Float64 += net(t)
+	}
+	return balance
+}
+
+func net(t types.Transaction) float64 {
+	var amount float64
+	if t.Credit.Valid {
+		amount += t.Credit.Float64
+	}
+	if t.Debit.Valid {
+		amount -= t.Debit.Float64
+	}
+	return amount
+}
+
+func reverse(rows []types.Transaction) {
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"valyx/aggregator/types"
+)
+
+func amount(v float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: v, Valid: true}
+}
+
+// row is a transaction of account "hdfc"; a negative amount is a debit.
+func row(date, description string, net, balance float64) types.Transaction {
+	t := types.Transaction{AccountID: "hdfc", Date: date, Description: description, Balance: amount(balance)}
+	if net < 0 {
+		t.Debit = amount(-net)
+	} else {
+		t.Credit = amount(net)
+	}
+	return t
+}
+
+func descriptions(rows []types.Transaction) (names []string, seqs []int) {
+	for _, t := range rows {
+		names = append(names, t.Description)
+		seqs = append(seqs, t.Seq)
+	}
+	return names, seqs
+}
+
+func TestCheckBalancesGap(t *testing.T) {
+	rows := []types.Transaction{
+		row("2023-08-01", "SALARY", 1000, 1000),
+		row("2023-08-02", "ATM", -100, 900),
+		row("2023-08-03", "ATM", -100, 700),
+	}
+	got := checkBalances(rows)
+	want := &BalanceCheck{Checked: 3, Breaks: []BalanceBreak{{
+		AccountID: "hdfc", Date: "2023-08-03", Description: "ATM", Kind: BreakGap, Expected: 800, Actual: 700,
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkBalances = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckBalancesSignSwap(t *testing.T) {
+	rows := []types.Transaction{
+		row("2023-08-01", "SALARY", 1000, 1000),
+		row("2023-08-02", "REFUND", 100, 900),
+		row("2023-08-03", "ATM", -100, 800),
+	}
+	got := checkBalances(rows)
+	want := &BalanceCheck{Checked: 3, Breaks: []BalanceBreak{{
+		AccountID: "hdfc", Date: "2023-08-02", Description: "REFUND", Kind: BreakSign, Expected: 1100, Actual: 900,
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkBalances = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckBalancesReversedStatement(t *testing.T) {
+	rows := []types.Transaction{
+		row("2023-08-03", "RENT", -100, 800),
+		row("2023-08-02", "ATM", -100, 900),
+		row("2023-08-01", "SALARY", 1000, 1000),
+	}
+	if got := checkBalances(rows); !reflect.DeepEqual(got, &BalanceCheck{Checked: 3}) {
+		t.Errorf("checkBalances = %+v", got)
+	}
+
+	// The rows stay as listed but are numbered oldest first.
+	names, seqs := descriptions(rows)
+	if !reflect.DeepEqual(names, []string{"RENT", "ATM", "SALARY"}) || !reflect.DeepEqual(seqs, []int{2, 1, 0}) {
+		t.Errorf("rows = %v, seqs = %v", names, seqs)
+	}
+}
+
+func TestCheckBalancesSameDayShuffle(t *testing.T) {
+	rows := []types.Transaction{
+		row("2023-08-01", "SALARY", 1000, 1000),
+		row("2023-08-02", "ATM", -50, 850),
+		row("2023-08-02", "RENT", -100, 900),
+		row("2023-08-02", "CASHBACK", 20, 870),
+		row("2023-08-03", "UPI", -70, 800),
+	}
+	if got := checkBalances(rows); !reflect.DeepEqual(got, &BalanceCheck{Checked: 5, Reordered: 2}) {
+		t.Errorf("checkBalances = %+v", got)
+	}
+
+	names, seqs := descriptions(rows)
+	if !reflect.DeepEqual(names, []string{"SALARY", "RENT", "ATM", "CASHBACK", "UPI"}) || !reflect.DeepEqual(seqs, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("rows = %v, seqs = %v", names, seqs)
+	}
+}
+
+func TestCheckBalancesWithoutBalances(t *testing.T) {
+	rows := []types.Transaction{row("2023-08-01", "SALARY", 1000, 0)}
+	rows[0].Balance = sql.NullFloat64{}
+	if got := checkBalances(rows); got != nil {
+		t.Errorf("checkBalances = %+v, want nil", got)
+	}
+}
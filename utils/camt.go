@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"valyx/aggregator/types"
+)
+
+// camtDocument covers both camt.053 (BkToCstmrStmt/Stmt) and camt.052
+// (BkToCstmrAcctRpt/Rpt). Namespaces are left out of the tags so any message
+// version decodes.
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+	Reports    []camtStatement `xml:"BkToCstmrAcctRpt>Rpt"`
+}
+
+type camtStatement struct {
+	Id      string        `xml:"Id"`
+	Acct    camtAccount   `xml:"Acct"`
+	Bal     []camtBalance `xml:"Bal"`
+	Entries []camtEntry   `xml:"Ntry"`
+}
+
+type camtAccount struct {
+	IBAN  string `xml:"Id>IBAN"`
+	Other string `xml:"Id>Othr>Id"`
+}
+
+type camtBalance struct {
+	Code      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amt       camtAmount `xml:"Amt"`
+	CdtDbtInd string     `xml:"CdtDbtInd"`
+	Dt        camtDate   `xml:"Dt"`
+}
+
+type camtAmount struct {
+	Value string `xml:",chardata"`
+	Ccy   string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Dt   string `xml:"Dt"`
+	DtTm string `xml:"DtTm"`
+}
+
+type camtEntry struct {
+	NtryRef       string          `xml:"NtryRef"`
+	Amt           camtAmount      `xml:"Amt"`
+	CdtDbtInd     string          `xml:"CdtDbtInd"`
+	Sts           camtStatus      `xml:"Sts"`
+	BookgDt       camtDate        `xml:"BookgDt"`
+	ValDt         camtDate        `xml:"ValDt"`
+	AcctSvcrRef   string          `xml:"AcctSvcrRef"`
+	Details       []camtTxDetails `xml:"NtryDtls>TxDtls"`
+	AddtlNtryInf  string          `xml:"AddtlNtryInf"`
+	BankTxCodeDom string          `xml:"BkTxCd>Domn>Cd"`
+}
+
+// camtStatus holds the entry status, which is plain text (BOOK) up to
+// camt.053.001.07 and wrapped in Cd from version 08 on.
+type camtStatus struct {
+	Text string `xml:",chardata"`
+	Cd   string `xml:"Cd"`
+}
+
+type camtTxDetails struct {
+	EndToEndId  string   `xml:"Refs>EndToEndId"`
+	TxId        string   `xml:"Refs>TxId"`
+	AcctSvcrRef string   `xml:"Refs>AcctSvcrRef"`
+	Ustrd       []string `xml:"RmtInf>Ustrd"`
+	StrdRef     []string `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	AddtlTxInf  string   `xml:"AddtlTxInf"`
+}
+
+// ParseCAMT reads ISO 20022 camt.053 statements and camt.052 account reports.
+// Every Stmt/Rpt block is imported; only booked entries become transactions
+// since pending ones are reported again once they post.
+func ParseCAMT(r io.Reader) ([]types.Transaction, error) {
+	var doc camtDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding camt document: %v", err)
+	}
+
+	statements := append(doc.Statements, doc.Reports...)
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("no Stmt or Rpt block found in camt document")
+	}
+
+	var transactions []types.Transaction
+	for _, stmt := range statements {
+		parsed, err := parseCAMTStatement(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("statement %s: %v", stmt.Id, err)
+		}
+		transactions = append(transactions, parsed...)
+	}
+	return transactions, nil
+}
+
+func parseCAMTStatement(stmt camtStatement) ([]types.Transaction, error) {
+	accountId := stmt.Acct.IBAN
+	if accountId == "" {
+		accountId = stmt.Acct.Other
+	}
+	if accountId == "" {
+		return nil, fmt.Errorf("no account id")
+	}
+
+	var transactions []types.Transaction
+	for _, entry := range stmt.Entries {
+		status := strings.TrimSpace(entry.Sts.Text)
+		if entry.Sts.Cd != "" {
+			status = entry.Sts.Cd
+		}
+		if status != "" && status != "BOOK" {
+			continue
+		}
+
+		amount, err := strconv.ParseFloat(strings.TrimSpace(entry.Amt.Value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount %q in entry %s", entry.Amt.Value, entry.NtryRef)
+		}
+
+		bookingDate := entry.BookgDt.date()
+		valueDate := entry.ValDt.date()
+		if bookingDate == "" {
+			bookingDate = valueDate
+		}
+		if bookingDate == "" {
+			return nil, fmt.Errorf("entry %s has no booking or value date", entry.NtryRef)
+		}
+
+		t := types.Transaction{
+			AccountID:   accountId,
+			Date:        bookingDate,
+			ValueDate:   valueDate,
+			Description: entry.remittanceInfo(),
+			ExternalID:  entry.bankReference(),
+			Reference:   entry.reference(),
+		}
+		if entry.CdtDbtInd == "DBIT" {
+			t.Debit = sql.NullFloat64{Float64: amount, Valid: true}
+		} else {
+			t.Credit = sql.NullFloat64{Float64: amount, Valid: true}
+		}
+		transactions = append(transactions, t)
+	}
+
+	opening, hasOpening := stmt.balance("OPBD", "PRCD", "ITBD")
+	closing, hasClosing := stmt.balance("CLBD")
+	switch {
+	case hasOpening:
+		running := opening
+		for i := range transactions {
+			running += transactions[i].Credit.Float64 - transactions[i].Debit.Float64
+			transactions[i].Balance = sql.NullFloat64{Float64: math.Round(running*100) / 100, Valid: true}
+		}
+		if hasClosing && !amountsEqual(running, closing) {
+			return nil, fmt.Errorf("opening balance %.2f plus entries gives %.2f, closing balance is %.2f", opening, running, closing)
+		}
+	case hasClosing:
+		fillBalancesBackwards(transactions, closing)
+	}
+
+	return transactions, nil
+}
+
+// balance returns the first balance of the given type codes, signed by its
+// credit/debit indicator.
+func (s camtStatement) balance(codes ...string) (float64, bool) {
+	for _, code := range codes {
+		for _, bal := range s.Bal {
+			if bal.Code != code {
+				continue
+			}
+			amount, err := strconv.ParseFloat(strings.TrimSpace(bal.Amt.Value), 64)
+			if err != nil {
+				continue
+			}
+			if bal.CdtDbtInd == "DBIT" {
+				amount = -amount
+			}
+			return amount, true
+		}
+	}
+	return 0, false
+}
+
+func (d camtDate) date() string {
+	if d.Dt != "" {
+		return strings.TrimSpace(d.Dt)
+	}
+	if len(d.DtTm) >= 10 {
+		return d.DtTm[:10]
+	}
+	return ""
+}
+
+// remittanceInfo prefers the unstructured remittance lines of the
+// transaction details and falls back to the additional entry information.
+func (e camtEntry) remittanceInfo() string {
+	var parts []string
+	for _, details := range e.Details {
+		parts = append(parts, details.Ustrd...)
+		parts = append(parts, details.StrdRef...)
+		if len(details.Ustrd) == 0 && len(details.StrdRef) == 0 {
+			parts = append(parts, details.AddtlTxInf)
+		}
+	}
+	if description := joinNonEmpty(parts...); description != "" {
+		return description
+	}
+	if e.AddtlNtryInf != "" {
+		return strings.TrimSpace(e.AddtlNtryInf)
+	}
+	return e.BankTxCodeDom
+}
+
+// bankReference is the account servicer's reference, the one ID the bank
+// keeps unique across statements.
+func (e camtEntry) bankReference() string {
+	if e.AcctSvcrRef != "" {
+		return e.AcctSvcrRef
+	}
+	for _, details := range e.Details {
+		if details.AcctSvcrRef != "" {
+			return details.AcctSvcrRef
+		}
+	}
+	return ""
+}
+
+// reference is the payment's own reference, which need not be unique.
+func (e camtEntry) reference() string {
+	for _, details := range e.Details {
+		for _, ref := range []string{details.TxId, details.EndToEndId} {
+			if ref != "" && ref != "NOTPROVIDED" {
+				return ref
+			}
+		}
+	}
+	return e.NtryRef
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"valyx/aggregator/types"
+)
+
+// camtStatementDoc wraps balances and entries in a camt.053 statement of
+// account X.
+func camtStatementDoc(body string) string {
+	return `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"><BkToCstmrStmt><Stmt><Id>S</Id>
+		<Acct><Id><IBAN>X</IBAN></Id></Acct>` + body + `</Stmt></BkToCstmrStmt></Document>`
+}
+
+func camtBal(code, amt, ind string) string {
+	return `<Bal><Tp><CdOrPrtry><Cd>` + code + `</Cd></CdOrPrtry></Tp><Amt Ccy="INR">` + amt + `</Amt><CdtDbtInd>` + ind + `</CdtDbtInd></Bal>`
+}
+
+func camtNtry(date, amt, ind, status string) string {
+	return `<Ntry><Amt Ccy="INR">` + amt + `</Amt><CdtDbtInd>` + ind + `</CdtDbtInd>` + status +
+		`<BookgDt><Dt>` + date + `</Dt></BookgDt></Ntry>`
+}
+
+func TestParseCAMT(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			name: "only booked entries are kept",
+			doc: camtStatementDoc(
+				camtNtry("2023-08-01", "1", "CRDT", "<Sts>BOOK</Sts>") +
+					camtNtry("2023-08-02", "2", "CRDT", "<Sts><Cd>BOOK</Cd></Sts>") +
+					camtNtry("2023-08-03", "3", "CRDT", "") +
+					camtNtry("2023-08-04", "4", "DBIT", "<Sts>PDNG</Sts>") +
+					camtNtry("2023-08-05", "5", "DBIT", "<Sts><Cd>INFO</Cd></Sts>")),
+			want: []string{"2023-08-01 +1.00", "2023-08-02 +2.00", "2023-08-03 +3.00"},
+		},
+		{
+			name: "balances run forward from OPBD and reconcile with CLBD",
+			doc: camtStatementDoc(camtBal("OPBD", "1000000.00", "CRDT") + camtBal("CLBD", "1001742.42", "CRDT") +
+				camtNtry("2023-08-05", "6361.88", "CRDT", "<Sts>BOOK</Sts>") +
+				camtNtry("2023-08-06", "4619.46", "DBIT", "<Sts>BOOK</Sts>") +
+				camtNtry("2023-08-31", "99.00", "DBIT", "<Sts>PDNG</Sts>")),
+			want: []string{"2023-08-05 +6361.88 =1006361.88", "2023-08-06 -4619.46 =1001742.42"},
+		},
+		{
+			name: "camt.052 interim balance opens an overdrawn account",
+			doc: `<Document><BkToCstmrAcctRpt><Rpt><Id>R</Id><Acct><Id><IBAN>X</IBAN></Id></Acct>` +
+				camtBal("ITBD", "25", "DBIT") +
+				camtNtry("2023-08-07", "25", "DBIT", "<Sts><Cd>BOOK</Cd></Sts>") +
+				`</Rpt></BkToCstmrAcctRpt></Document>`,
+			want: []string{"2023-08-07 -25.00 =-50.00"},
+		},
+		{
+			name: "CLBD alone fills balances backwards",
+			doc: camtStatementDoc(camtBal("CLBD", "106", "CRDT") +
+				camtNtry("2023-08-05", "10", "CRDT", "<Sts>BOOK</Sts>") +
+				camtNtry("2023-08-06", "4", "DBIT", "<Sts>BOOK</Sts>")),
+			want: []string{"2023-08-05 +10.00 =110.00", "2023-08-06 -4.00 =106.00"},
+		},
+	} {
+		got, err := ParseCAMT(strings.NewReader(test.doc))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if lines := movements(got); !reflect.DeepEqual(lines, test.want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", test.name, lines, test.want)
+		}
+	}
+}
+
+func TestParseCAMTEntryDetails(t *testing.T) {
+	doc := `<Document><BkToCstmrStmt><Stmt><Id>S</Id>
+		<Acct><Id><Othr><Id>50100123451234</Id></Othr></Id></Acct>
+		<Ntry>
+			<NtryRef>1</NtryRef><Amt>6361.88</Amt><CdtDbtInd>CRDT</CdtDbtInd>
+			<BookgDt><Dt>2023-08-05</Dt></BookgDt><ValDt><Dt>2023-08-05</Dt></ValDt>
+			<AcctSvcrRef>HDFC0001</AcctSvcrRef>
+			<NtryDtls><TxDtls><Refs><EndToEndId>E2E-1</EndToEndId></Refs><RmtInf><Ustrd>NEFTIN FROM ACME</Ustrd></RmtInf></TxDtls></NtryDtls>
+		</Ntry>
+		<Ntry>
+			<NtryRef>2</NtryRef><Amt>4619.46</Amt><CdtDbtInd>DBIT</CdtDbtInd>
+			<BookgDt><DtTm>2023-08-06T10:15:00</DtTm></BookgDt><ValDt><Dt>2023-08-07</Dt></ValDt>
+			<NtryDtls><TxDtls><Refs><AcctSvcrRef>HDFC0002</AcctSvcrRef><EndToEndId>NOTPROVIDED</EndToEndId></Refs><AddtlTxInf>TRANSFER RENT</AddtlTxInf></TxDtls></NtryDtls>
+		</Ntry>
+		<Ntry>
+			<NtryRef>3</NtryRef><Amt>1</Amt><CdtDbtInd>DBIT</CdtDbtInd><ValDt><Dt>2023-08-08</Dt></ValDt>
+			<AddtlNtryInf> SMS CHARGES </AddtlNtryInf>
+		</Ntry>
+		<Ntry>
+			<Amt>2</Amt><CdtDbtInd>DBIT</CdtDbtInd><BookgDt><Dt>2023-08-09</Dt></BookgDt>
+			<BkTxCd><Domn><Cd>PMNT</Cd></Domn></BkTxCd>
+		</Ntry>
+	</Stmt></BkToCstmrStmt></Document>`
+
+	got, err := ParseCAMT(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.Transaction{
+		{AccountID: "50100123451234", Date: "2023-08-05", ValueDate: "2023-08-05", Description: "NEFTIN FROM ACME", Credit: amount(6361.88), ExternalID: "HDFC0001", Reference: "E2E-1"},
+		// NOTPROVIDED is no reference, so the entry's own is used.
+		{AccountID: "50100123451234", Date: "2023-08-06", ValueDate: "2023-08-07", Description: "TRANSFER RENT", Debit: amount(4619.46), ExternalID: "HDFC0002", Reference: "2"},
+		// Without a booking date the entry is dated by its value date.
+		{AccountID: "50100123451234", Date: "2023-08-08", ValueDate: "2023-08-08", Description: "SMS CHARGES", Debit: amount(1), Reference: "3"},
+		{AccountID: "50100123451234", Date: "2023-08-09", Description: "PMNT", Debit: amount(2)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCAMT:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseCAMTPrefersIBAN(t *testing.T) {
+	doc := `<Document><BkToCstmrStmt><Stmt><Id>S</Id>
+		<Acct><Id><IBAN>GB33BUKB20201555555555</IBAN><Othr><Id>555555555</Id></Othr></Id></Acct>` +
+		camtNtry("2023-08-01", "1", "CRDT", "") + `</Stmt></BkToCstmrStmt></Document>`
+	got, err := ParseCAMT(strings.NewReader(doc))
+	if err != nil || len(got) != 1 || got[0].AccountID != "GB33BUKB20201555555555" {
+		t.Errorf("ParseCAMT = %+v, %v", got, err)
+	}
+}
+
+func TestParseCAMTErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, doc, reason string
+	}{
+		{"not XML", "Date,Amount", "error decoding camt document"},
+		{"no statement", "<Document><BkToCstmrStmt></BkToCstmrStmt></Document>", "no Stmt or Rpt block"},
+		{"no account", "<Document><BkToCstmrStmt><Stmt><Id>S</Id></Stmt></BkToCstmrStmt></Document>", "statement S: no account id"},
+		{"bad amount", camtStatementDoc(camtNtry("2023-08-01", "1,00", "CRDT", "")), `invalid amount "1,00"`},
+		{"no date", camtStatementDoc(`<Ntry><NtryRef>7</NtryRef><Amt>1</Amt></Ntry>`), "entry 7 has no booking or value date"},
+		{
+			"OPBD to CLBD mismatch",
+			camtStatementDoc(camtBal("OPBD", "10", "CRDT") + camtBal("CLBD", "10", "CRDT") + camtNtry("2023-08-01", "1", "CRDT", "<Sts>BOOK</Sts>")),
+			"closing balance is 10.00",
+		},
+	} {
+		_, err := ParseCAMT(strings.NewReader(test.doc))
+		if err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: err = %v, want %q", test.name, err, test.reason)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/shakinm/xlsReader/xls"
+	"github.com/spf13/viper"
+	"github.com/xuri/excelize/v2"
+)
+
+func (p *Processor) processXLSXFile(ctx context.Context, task IngestTask, report *IngestReport) error {
+	filePath := task.Path
+	f, err := excelize.OpenFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sheets := make(map[string][][]string)
+	for _, name := range f.GetSheetList() {
+		rows, err := f.GetRows(name, excelize.Options{RawCellValue: true})
+		if err != nil {
+			return fmt.Errorf("error reading sheet %s of %s: %v", name, filePath, err)
+		}
+		sheets[name] = rows
+	}
+
+	rows, err := p.selectSheet(f.GetSheetList(), sheets, filePath, task.Profile)
+	if err != nil {
+		return err
+	}
+	return p.processRows(ctx, rows, task, report)
+}
+
+func (p *Processor) processXLSFile(ctx context.Context, task IngestTask, report *IngestReport) error {
+	filePath := task.Path
+	workbook, err := xls.OpenFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	sheets := make(map[string][][]string)
+	for i := 0; i < workbook.GetNumberSheets(); i++ {
+		sheet, err := workbook.GetSheet(i)
+		if err != nil {
+			return fmt.Errorf("error reading sheet %d of %s: %v", i, filePath, err)
+		}
+
+		var rows [][]string
+		for _, row := range sheet.GetRows() {
+			var record []string
+			for _, col := range row.GetCols() {
+				record = append(record, col.GetString())
+			}
+			rows = append(rows, record)
+		}
+		names = append(names, sheet.GetName())
+		sheets[sheet.GetName()] = rows
+	}
+
+	rows, err := p.selectSheet(names, sheets, filePath, task.Profile)
+	if err != nil {
+		return err
+	}
+	return p.processRows(ctx, rows, task, report)
+}
+
+// selectSheet picks the worksheet holding the transactions. EXCEL_SHEET forces
+// a sheet by name, otherwise the first sheet claimed by a profile or with
+// recognisable columns wins.
+func (p *Processor) selectSheet(names []string, sheets map[string][][]string, filePath string, profile string) ([][]string, error) {
+	if name := viper.GetString("EXCEL_SHEET"); name != "" {
+		rows, ok := sheets[name]
+		if !ok {
+			return nil, fmt.Errorf("sheet %s not found in %s", name, filePath)
+		}
+		return rows, nil
+	}
+
+	var firstErr error
+	for _, name := range names {
+		if _, _, err := p.selectProfile(profile, filepath.Base(filePath), sheets[name]); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		return sheets[name], nil
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no sheets found in %s", filePath)
+	}
+	return nil, firstErr
+}
+
+func isBlankRow(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
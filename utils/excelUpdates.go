@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -13,92 +18,435 @@ import (
 	"valyx/aggregator/types"
 
 	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+	"github.com/xuri/excelize/v2"
 )
 
+// Statement is what a StatementParser extracts from one file.
+type Statement struct {
+	Transactions []types.Transaction
+	Accounts     []types.AccountSummary
+}
+
+// StatementParser reads a structured statement format that carries its own
+// account identifiers, as opposed to the tabular CSV and Excel exports.
+type StatementParser func(r io.Reader) (*Statement, error)
+
+// transactionsOnly adapts parsers of formats without account level data.
+func transactionsOnly(parse func(r io.Reader) ([]types.Transaction, error)) StatementParser {
+	return func(r io.Reader) (*Statement, error) {
+		transactions, err := parse(r)
+		if err != nil {
+			return nil, err
+		}
+		return &Statement{Transactions: transactions}, nil
+	}
+}
+
 type Processor struct {
-	db types.DB
+	db       types.DB
+	profiles []StatementProfile
+	parsers  map[string]StatementParser
+	accounts KeyLocks
 }
 
 func NewProcessor(db types.DB) *Processor {
-	return &Processor{db: db}
+	p := &Processor{db: db, parsers: make(map[string]StatementParser)}
+	p.RegisterParser(".ofx", transactionsOnly(ParseOFX))
+	p.RegisterParser(".qfx", transactionsOnly(ParseOFX))
+	for _, ext := range []string{".sta", ".mt940", ".940", ".mt942", ".942"} {
+		p.RegisterParser(ext, transactionsOnly(ParseMT940))
+	}
+	p.RegisterParser(".xml", ParseXMLStatement)
+	p.RegisterParser(".json", ParseDepositJSON)
+	return p
 }
 
-func (p *Processor) ReadExcelFiles(path string, db types.DB) error {
+// RegisterParser makes ReadExcelFiles hand files with the given extension to
+// parse instead of skipping them.
+func (p *Processor) RegisterParser(ext string, parse StatementParser) {
+	p.parsers[strings.ToLower(ext)] = parse
+}
 
-	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+// ReadExcelFiles imports every supported file under path, INGEST_WORKERS
+// files at a time, identifying each file's account from its header block or
+// else its name. Statements in zip archives, mailboxes and Maildirs are
+// extracted and imported too. Files that fail are skipped unless the row
+// error policy is abort.
+func (p *Processor) ReadExcelFiles(ctx context.Context, path string, db types.DB) error {
+	var found []IngestTask
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if !info.IsDir() && filepath.Ext(path) == ".csv" {
-			accountId := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
-			if err := p.processCSVFile(path, db, accountId); err != nil {
-				return err
+		if info.IsDir() {
+			if containerKind(path) == maildir {
+				found = append(found, IngestTask{Path: path})
+				return filepath.SkipDir
 			}
+			return nil
+		}
+		if p.Supports(filepath.Ext(path)) {
+			found = append(found, IngestTask{Path: path})
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "expand-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var tasks []IngestTask
+	for _, task := range found {
+		members, err := p.Expand(task, dir)
+		if err != nil {
+			log.Printf("skipping statements in %s: %v", task.Path, err)
+			if errorPolicy() == PolicyAbort {
+				return err
+			}
+		}
+		tasks = append(tasks, members...)
+	}
+
+	var firstErr error
+	for _, result := range p.IngestFiles(ctx, tasks, viper.GetInt("INGEST_WORKERS")) {
+		switch {
+		case result.Report == nil, errors.Is(result.Err, context.Canceled):
+			// Never started or not stored: the import was cancelled.
+		case result.Err != nil:
+			log.Printf("skipping %s: %v", result.Report.File, result.Err)
+			if firstErr == nil && errorPolicy() == PolicyAbort {
+				firstErr = result.Err
+			}
+		default:
+			result.Report.log()
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
 }
 
-func (p *Processor) processCSVFile(filePath string, db types.DB, accountId string) error {
+func (p *Processor) processCSVFile(ctx context.Context, task IngestTask, report *IngestReport) error {
+	filePath := task.Path
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	text, encoding, err := decodeText(content)
+	if err != nil {
+		return err
+	}
+
+	fileName := filepath.Base(filePath)
+	delimiter := sniffDelimiter(text)
+	if profile := p.profileForFile(fileName); profile != nil && profile.Delimiter != "" {
+		delimiter = []rune(profile.Delimiter)[0]
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	err = p.processRows(ctx, rows, task, report)
+	var mappingReport *MappingReport
+	if errors.As(err, &mappingReport) {
+		mappingReport.Encoding = encoding
+		mappingReport.Delimiter = string(delimiter)
+	}
+	return err
+}
+
+// processParsedFile ingests a file through a registered parser. The accounts
+// the file names are stored under their keys, unless the task gives an
+// account ID, which then takes every row of the file. Otherwise the task's
+// account is only used for transactions the file does not attribute to one.
+func (p *Processor) processParsedFile(ctx context.Context, task IngestTask, parse StatementParser, report *IngestReport) error {
+	filePath := task.Path
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.Read()
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+	statement, err := parse(file)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", report.File, err)
+	}
+
+	statement.keyAccounts()
+	if task.AccountID != "" {
+		for i := range statement.Accounts {
+			statement.Accounts[i].AccountID = task.AccountID
 		}
-		if err != nil {
-			return err
+		for i := range statement.Transactions {
+			statement.Transactions[i].AccountID = task.AccountID
+		}
+	}
+	account := task.Account.merge(StatementAccount{})
+	report.AccountID = task.accountID(account)
+	return p.ingestStatement(ctx, statement, report.AccountID, report)
+}
+
+// IngestStatement stores the account summaries and transactions of a parsed
+// statement in one batch. accountId is only used for transactions without an
+// account.
+func (p *Processor) IngestStatement(statement *Statement, accountId string) error {
+	return p.ingestStatement(context.Background(), statement, accountId, &IngestReport{})
+}
+
+func (p *Processor) ingestStatement(ctx context.Context, statement *Statement, accountId string, report *IngestReport) error {
+	batch := types.Batch{Accounts: statement.Accounts}
+
+	var accounts []string
+	add := func(account string) {
+		for _, seen := range accounts {
+			if seen == account {
+				return
+			}
+		}
+		accounts = append(accounts, account)
+	}
+	for _, s := range batch.Accounts {
+		add(s.AccountID)
+	}
+	seen := fingerprints{}
+	for _, t := range statement.Transactions {
+		if t.AccountID == "" {
+			t.AccountID = accountId
 		}
+		t.Source = report.File
+		seen.assign(&t)
+		batch.Transactions = append(batch.Transactions, t)
+		add(t.AccountID)
+	}
+	// The report names every account the rows go to.
+	if len(accounts) > 0 {
+		report.AccountID = strings.Join(accounts, ",")
+	}
+	defer p.accounts.Lock(accounts...)()
+
+	report.Balance = checkBalances(batch.Transactions)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	inserted, err := p.db.InsertBatch(batch)
+	if err != nil {
+		return err
+	}
+	report.Rows = len(batch.Transactions)
+	report.count(inserted)
+	return nil
+}
+
+func (p *Processor) processRows(ctx context.Context, rows [][]string, task IngestTask, report *IngestReport) error {
+	fileName := filepath.Base(task.Path)
+	profile, headerIdx, err := p.selectProfile(task.Profile, fileName, rows)
+	if err != nil {
+		return err
+	}
+	report.Profile = profile.Name
+
+	var header []string
+	dataStart := profile.SkipRows
+	if headerIdx >= 0 && headerIdx < len(rows) {
+		header = rows[headerIdx]
+		dataStart = headerIdx + 1
+	}
+
+	// The rows above the header, or those skipped without one, are the
+	// statement's banner.
+	bannerEnd := headerIdx
+	if bannerEnd < 0 {
+		bannerEnd = dataStart
+	}
+	if bannerEnd > len(rows) {
+		bannerEnd = len(rows)
+	}
+	account := task.Account.merge(readBanner(rows[:bannerEnd]))
+	accountId := task.accountID(account)
+	report.AccountID = accountId
+	defer p.accounts.Lock(accountId)()
+	if !account.empty() {
+		report.Account = &account
+	}
+
+	cols, err := profile.resolve(header)
+	if err != nil {
+		return fmt.Errorf("error mapping columns of %s: %v", fileName, err)
+	}
 
-		if err := p.processData(record, accountId); err != nil {
+	if dataStart > len(rows) {
+		return nil
+	}
+
+	policy := errorPolicy()
+	records := rows[dataStart:]
+	batch := p.parseRecords(records, dataStart+1, cols, accountId, report)
+	report.Balance = checkBalances(batch.Transactions)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if account.Number != "" && accountId == account.key() {
+		batch.Accounts = append(batch.Accounts, account.summary(accountId, batch.Transactions))
+	}
+
+	if len(report.Errors) > 0 {
+		if err := p.quarantine(report, policy, records, dataStart+1, accountId, cols); err != nil {
 			return err
 		}
+		switch policy {
+		case PolicyAbort:
+			return &AbortError{File: report.File, RowError: report.Errors[0]}
+		case PolicyReject:
+			report.Rejected = true
+			return nil
+		}
 	}
 
+	inserted, err := p.db.InsertBatch(batch)
+	if err != nil {
+		return err
+	}
+	report.count(inserted)
 	return nil
 }
 
-func (p *Processor) processData(record []string, accountId string) error {
-	parsedDate, err := time.Parse("02/01/2006", record[0])
+// parseRecords parses the data rows of a statement, records[i] being line
+// firstLine+i, into a batch of transactions. Rows that do not parse are
+// reported instead.
+func (p *Processor) parseRecords(records [][]string, firstLine int, cols *columnMap, accountId string, report *IngestReport) types.Batch {
+	var batch types.Batch
+	seen := fingerprints{}
+	for i, record := range records {
+		if isBlankRow(record) {
+			continue
+		}
+		report.Rows++
+
+		transaction, err := p.processData(record, cols, accountId)
+		if err != nil {
+			report.Errors = append(report.Errors, RowError{Line: firstLine + i, Record: record, Reason: err.Error()})
+			continue
+		}
+		transaction.Source = report.File
+		seen.assign(&transaction)
+		batch.Transactions = append(batch.Transactions, transaction)
+	}
+	return batch
+}
+
+func (p *Processor) processData(record []string, cols *columnMap, accountId string) (types.Transaction, error) {
+	// A profile that names its date layouts is held to them: a date in any
+	// other layout is a row error rather than a guess.
+	value := cell(record, cols.date)
+	var parsedDate time.Time
+	var err error
+	if len(cols.profile.DateFormats) > 0 {
+		parsedDate, err = parseDate(value, cols.profile.DateFormats)
+	} else {
+		parsedDate, err = ParseDate(value)
+	}
 	if err != nil {
-		panic(err)
+		return types.Transaction{}, err
 	}
 	formattedDate := parsedDate.Format("2006-01-02")
 
-	debit := stringToNullNumeric(record[2])
-	credit := stringToNullNumeric(record[3])
-	balance := stringToNullNumeric(record[4])
+	var debit, credit sql.NullFloat64
+	switch cols.profile.SignConvention {
+	case SignSigned, SignDebitPositive:
+		if value := cell(record, cols.amount); value != "" {
+			amount, marked, err := ParseAmount(value)
+			if err != nil {
+				return types.Transaction{}, fmt.Errorf("invalid amount: %v", err)
+			}
+			// A Dr or Cr marker says which it is whatever the convention.
+			if cols.profile.SignConvention == SignDebitPositive && !marked {
+				amount = -amount
+			}
+			if amount < 0 {
+				debit = sql.NullFloat64{Float64: -amount, Valid: true}
+			} else {
+				credit = sql.NullFloat64{Float64: amount, Valid: true}
+			}
+		}
+	default:
+		// The columns already say which way the money went, so a Dr marker
+		// or minus in them is redundant.
+		if debit, err = stringToNullNumeric(cell(record, cols.debit)); err != nil {
+			return types.Transaction{}, fmt.Errorf("invalid debit: %v", err)
+		}
+		if credit, err = stringToNullNumeric(cell(record, cols.credit)); err != nil {
+			return types.Transaction{}, fmt.Errorf("invalid credit: %v", err)
+		}
+		debit.Float64, credit.Float64 = math.Abs(debit.Float64), math.Abs(credit.Float64)
+	}
+	balance, err := stringToNullNumeric(cell(record, cols.balance))
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("invalid balance: %v", err)
+	}
 
 	transaction := types.Transaction{
 		AccountID:   accountId,
 		Date:        formattedDate,
-		Description: record[1],
+		Description: cell(record, cols.description),
 		Debit:       debit,
 		Credit:      credit,
 		Balance:     balance,
 	}
 
-	return p.db.InsertTransaction(transaction)
+	return transaction, nil
+}
+
+// fingerprints counts the transactions of one statement by fingerprint key,
+// so identical rows get successive ordinals.
+type fingerprints map[string]int
+
+func (f fingerprints) assign(t *types.Transaction) {
+	key := t.FingerprintKey()
+	t.Fingerprint = types.Fingerprint(key, f[key])
+	f[key]++
 }
 
-func stringToNullNumeric(s string) sql.NullFloat64 {
+// parseDate tries each layout in turn, ignoring the case of month names. ISO
+// dates and Excel serial numbers are always accepted since spreadsheet readers
+// may hand us either.
+func parseDate(value string, layouts []string) (time.Time, error) {
+	normalised := normaliseMonths(value)
+	for _, layout := range append(layouts[:len(layouts):len(layouts)], "2006-01-02") {
+		if t, err := time.Parse(layout, normalised); err == nil {
+			return t, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 && serial < 2958466 {
+		return excelize.ExcelDateToTime(serial, false)
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", value)
+}
+
+func stringToNullNumeric(s string) (sql.NullFloat64, error) {
 	if s == "" {
-		return sql.NullFloat64{Float64: 0, Valid: false}
+		return sql.NullFloat64{Float64: 0, Valid: false}, nil
 	}
-	var f float64
-	var err error
-	if f, err = strconv.ParseFloat(s, 64); err != nil {
-		panic(err)
+	f, _, err := ParseAmount(s)
+	if err != nil {
+		return sql.NullFloat64{}, err
 	}
-	return sql.NullFloat64{Float64: f, Valid: true}
+	return sql.NullFloat64{Float64: f, Valid: true}, nil
 }
 
 func stringToNull(s string) sql.NullString {
@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"valyx/aggregator/types"
+
+	"github.com/spf13/viper"
+)
+
+// What to do with a file that has rows we cannot parse, set by
+// ROW_ERROR_POLICY. Bad rows are quarantined along with the whole file under
+// every policy.
+const (
+	// PolicySkip imports the good rows of the file.
+	PolicySkip = "skip"
+	// PolicyReject imports nothing from the file but carries on with others.
+	PolicyReject = "reject"
+	// PolicyAbort imports nothing from the file and stops the import there.
+	PolicyAbort = "abort"
+)
+
+func errorPolicy() string {
+	switch policy := strings.ToLower(viper.GetString("ROW_ERROR_POLICY")); policy {
+	case PolicySkip, PolicyAbort:
+		return policy
+	default:
+		return PolicyReject
+	}
+}
+
+// RowError is a statement row that could not be parsed.
+type RowError struct {
+	Line   int      `json:"line"`
+	Record []string `json:"record"`
+	Reason string   `json:"reason"`
+}
+
+// IngestReport sums up the import of one file. Rows already stored by an
+// earlier import count as duplicates rather than inserted.
+type IngestReport struct {
+	// File is the file's name, or for a file extracted from an archive or
+	// email where it came from, e.g. "bundle.zip > aug/hdfc.csv" or
+	// "inbox.mbox > <id@example.com> > hdfc.csv".
+	File string `json:"file"`
+	// Profile is the statement profile the file was read with.
+	Profile string `json:"profile,omitempty"`
+	// AccountID is the account the rows were stored under, comma-separated
+	// when a structured file holds several, and Account the details the
+	// statement or its metadata gave for it.
+	AccountID  string            `json:"accountId"`
+	Account    *StatementAccount `json:"account,omitempty"`
+	Rows       int               `json:"rows"`
+	Inserted   int               `json:"inserted"`
+	Duplicates int               `json:"duplicates"`
+	Rejected   bool              `json:"rejected"`
+	Errors     []RowError        `json:"errors,omitempty"`
+	// Balance is the running balance check, nil when no row has a balance.
+	Balance *BalanceCheck `json:"balance,omitempty"`
+}
+
+// AbortError stops an import under PolicyAbort.
+type AbortError struct {
+	File string
+	RowError
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("%s line %d: %s", e.File, e.Line, e.Reason)
+}
+
+// IngestFile imports one statement file, picking the reader by extension and
+// the statement profile by name when the task names one. Row errors are
+// reported rather than returned unless the policy is abort. Once ctx is
+// cancelled the file is left unread, or unstored if it was being read.
+func (p *Processor) IngestFile(ctx context.Context, task IngestTask) (*IngestReport, error) {
+	filePath := task.Path
+	report := &IngestReport{File: task.source()}
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
+	var err error
+	ext := strings.ToLower(filepath.Ext(filePath))
+	switch ext {
+	case ".csv":
+		err = p.processCSVFile(ctx, task, report)
+	case ".xlsx", ".xlsm":
+		err = p.processXLSXFile(ctx, task, report)
+	case ".xls":
+		err = p.processXLSFile(ctx, task, report)
+	case zipArchive, mbox, email:
+		err = fmt.Errorf("%s holds statements to Expand rather than being one", report.File)
+	default:
+		parse, ok := p.parsers[ext]
+		if !ok {
+			return report, fmt.Errorf("unsupported statement format %q", ext)
+		}
+		err = p.processParsedFile(ctx, task, parse, report)
+	}
+	return report, err
+}
+
+// Supports reports whether IngestFile can read files with this extension, or
+// Expand can extract statements from them.
+func (p *Processor) Supports(ext string) bool {
+	switch ext = strings.ToLower(ext); ext {
+	case ".csv", ".xlsx", ".xlsm", ".xls", zipArchive, mbox, email:
+		return true
+	}
+	_, ok := p.parsers[ext]
+	return ok
+}
+
+// count splits the rows of a stored batch into new ones and duplicates.
+func (r *IngestReport) count(inserted int) {
+	r.Inserted = inserted
+	r.Duplicates = r.Rows - len(r.Errors) - inserted
+}
+
+func (r *IngestReport) log() {
+	switch {
+	case r.Rejected:
+		log.Printf("%s: rejected, %d of %d rows could not be parsed and were quarantined", r.File, len(r.Errors), r.Rows)
+	case len(r.Errors) > 0:
+		log.Printf("%s: inserted %d rows, skipped %d that were quarantined", r.File, r.Inserted, len(r.Errors))
+	}
+	if r.Balance != nil && len(r.Balance.Breaks) > 0 {
+		log.Printf("%s: running balance breaks at %d rows", r.File, len(r.Balance.Breaks))
+	}
+}
+
+// rowMapping is the part of a file's column mapping a quarantined row needs
+// to be parsed again on its own.
+type rowMapping struct {
+	DateFormats    []string `json:"dateFormats,omitempty"`
+	SignConvention string   `json:"signConvention,omitempty"`
+	Date           int      `json:"date"`
+	Description    int      `json:"description"`
+	Debit          int      `json:"debit"`
+	Credit         int      `json:"credit"`
+	Amount         int      `json:"amount"`
+	Balance        int      `json:"balance"`
+}
+
+func (cols *columnMap) mapping() rowMapping {
+	return rowMapping{
+		DateFormats:    cols.profile.DateFormats,
+		SignConvention: cols.profile.SignConvention,
+		Date:           cols.date,
+		Description:    cols.description,
+		Debit:          cols.debit,
+		Credit:         cols.credit,
+		Amount:         cols.amount,
+		Balance:        cols.balance,
+	}
+}
+
+func (m rowMapping) columnMap() *columnMap {
+	return &columnMap{
+		profile:     &StatementProfile{DateFormats: m.DateFormats, SignConvention: m.SignConvention},
+		date:        m.Date,
+		description: m.Description,
+		debit:       m.Debit,
+		credit:      m.Credit,
+		amount:      m.Amount,
+		balance:     m.Balance,
+	}
+}
+
+// quarantine keeps the rows of the report that did not parse together with
+// every row of their file, so the file can be imported again once they are
+// fixed.
+func (p *Processor) quarantine(report *IngestReport, policy string, records [][]string, firstLine int, accountId string, cols *columnMap) error {
+	mapping, err := json.Marshal(cols.mapping())
+	if err != nil {
+		return err
+	}
+
+	file := types.QuarantinedFile{
+		File:      report.File,
+		AccountID: accountId,
+		Mapping:   mapping,
+		Records:   records,
+		FirstLine: firstLine,
+		Policy:    policy,
+	}
+	rows := make([]types.QuarantinedRow, 0, len(report.Errors))
+	for _, rowErr := range report.Errors {
+		rows = append(rows, types.QuarantinedRow{
+			File:      report.File,
+			Line:      rowErr.Line,
+			AccountID: accountId,
+			Record:    rowErr.Record,
+			Reason:    rowErr.Reason,
+			Mapping:   mapping,
+		})
+	}
+	return p.db.QuarantineFile(file, rows)
+}
+
+// ResubmitFile imports a quarantined file again with the corrections made to
+// it so far. Every row keeps its place in the file, so identical rows get the
+// ordinals they had the first time and rows stored then are recognised as
+// duplicates. Unless the file was imported under PolicySkip nothing is stored
+// while any row still fails. Like any import, it waits for other files of
+// the account being stored.
+func (p *Processor) ResubmitFile(file types.QuarantinedFile) (*IngestReport, error) {
+	defer p.accounts.Lock(file.AccountID)()
+
+	var mapping rowMapping
+	if err := json.Unmarshal(file.Mapping, &mapping); err != nil {
+		return nil, fmt.Errorf("error decoding column mapping: %v", err)
+	}
+
+	report := &IngestReport{File: file.File, AccountID: file.AccountID}
+	batch := p.parseRecords(file.Records, file.FirstLine, mapping.columnMap(), file.AccountID, report)
+	report.Balance = checkBalances(batch.Transactions)
+	if len(report.Errors) > 0 && file.Policy != PolicySkip {
+		report.Rejected = true
+		return report, nil
+	}
+
+	inserted, err := p.db.InsertBatch(batch)
+	if err != nil {
+		return report, err
+	}
+	report.count(inserted)
+	return report, nil
+}
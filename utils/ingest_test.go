@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"valyx/aggregator/types"
+
+	"github.com/spf13/viper"
+)
+
+// memDB keeps transactions by fingerprint and account summaries by balance
+// time the way InsertBatch upserts them, and quarantined files the way
+// QuarantineFile stores them.
+type memDB struct {
+	mu          sync.Mutex
+	txns        map[string]types.Transaction
+	summaries   map[string]types.AccountSummary
+	files       []types.QuarantinedFile
+	quarantined []types.QuarantinedRow
+}
+
+func newMemDB() *memDB {
+	return &memDB{txns: make(map[string]types.Transaction), summaries: make(map[string]types.AccountSummary)}
+}
+
+func (db *memDB) InsertBatch(b types.Batch) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, s := range b.Accounts {
+		if s.BalanceDateTime >= db.summaries[s.AccountID].BalanceDateTime {
+			db.summaries[s.AccountID] = s
+		}
+	}
+	inserted := 0
+	for _, t := range b.Transactions {
+		if _, ok := db.txns[t.Fingerprint]; !ok {
+			db.txns[t.Fingerprint] = t
+			inserted++
+		}
+	}
+	return inserted, nil
+}
+
+func (db *memDB) QuarantineFile(file types.QuarantinedFile, rows []types.QuarantinedRow) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	file.ID = int64(len(db.files) + 1)
+	db.files = append(db.files, file)
+	for _, row := range rows {
+		row.FileID = file.ID
+		db.quarantined = append(db.quarantined, row)
+	}
+	return nil
+}
+
+func (db *memDB) QueryTransactions(keyword string, accounts []string, startTime, endTime time.Time) ([]types.Transaction, error) {
+	return nil, nil
+}
+func (db *memDB) GetUniqueKeywords() ([]string, error)     { return nil, nil }
+func (db *memDB) GetUniqueBankAccounts() ([]string, error) { return nil, nil }
+func (db *memDB) QueryTransactionsWithPagination(keyword string, accounts []string, startTime, endTime time.Time, limit, offset int, sortOrder string) ([]types.Transaction, error) {
+	return nil, nil
+}
+func (db *memDB) GetTrendData(category string, startTime, endTime time.Time) ([]types.TrendData, error) {
+	return nil, nil
+}
+func (db *memDB) GetAggregateData(category string, startTime, endTime time.Time) (types.AggregateData, error) {
+	return types.AggregateData{}, nil
+}
+func (db *memDB) Close() error { return nil }
+
+func writeStatement(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// A statement with a bad date on line 3 and two identical rows around it.
+const badStatement = `Date,Description,Debit,Credit,Balance
+05/08/2023,ATM,100.00,,900.00
+31/31/2023,ATM,100.00,,800.00
+06/08/2023,ATM,100.00,,700.00
+`
+
+func TestRejectQuarantinesWholeFile(t *testing.T) {
+	viper.Set("ROW_ERROR_POLICY", PolicyReject)
+	defer viper.Set("ROW_ERROR_POLICY", nil)
+
+	db := newMemDB()
+	p := NewProcessor(db)
+	report, err := p.IngestFile(context.Background(), IngestTask{Path: writeStatement(t, "hdfc.csv", badStatement), AccountID: "hdfc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Rejected || len(db.txns) != 0 {
+		t.Fatalf("rejected=%v, %d rows stored", report.Rejected, len(db.txns))
+	}
+	if len(db.files) != 1 || len(db.files[0].Records) != 3 || db.files[0].FirstLine != 2 {
+		t.Fatalf("quarantined files = %+v", db.files)
+	}
+	if len(db.quarantined) != 1 || db.quarantined[0].Line != 3 {
+		t.Fatalf("quarantined rows = %+v", db.quarantined)
+	}
+
+	file := db.files[0]
+	resubmitted, err := p.ResubmitFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resubmitted.Rejected || len(db.txns) != 0 {
+		t.Fatalf("unfixed file: rejected=%v, %d rows stored", resubmitted.Rejected, len(db.txns))
+	}
+
+	file.Records[1] = strings.Split("05/08/2023,ATM,100.00,,800.00", ",")
+	resubmitted, err = p.ResubmitFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resubmitted.Rejected || resubmitted.Inserted != 3 || len(db.txns) != 3 {
+		t.Fatalf("fixed file: %+v, %d rows stored", resubmitted, len(db.txns))
+	}
+}
+
+func TestSkipResubmitKeepsOrdinals(t *testing.T) {
+	viper.Set("ROW_ERROR_POLICY", PolicySkip)
+	defer viper.Set("ROW_ERROR_POLICY", nil)
+
+	// The two good rows are the same transaction twice, so they are stored
+	// with ordinals 0 and 1.
+	statement := `Date,Description,Debit,Credit,Balance
+05/08/2023,ATM,100.00,,
+31/31/2023,ATM,100.00,,
+05/08/2023,ATM,100.00,,
+`
+	db := newMemDB()
+	p := NewProcessor(db)
+	report, err := p.IngestFile(context.Background(), IngestTask{Path: writeStatement(t, "hdfc.csv", statement), AccountID: "hdfc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Inserted != 2 || len(db.quarantined) != 1 {
+		t.Fatalf("inserted %d, quarantined %+v", report.Inserted, db.quarantined)
+	}
+
+	// Fixed, the bad row is a third identical transaction: it must get the
+	// next ordinal rather than colliding with one already stored.
+	file := db.files[0]
+	file.Records[1] = strings.Split("05/08/2023,ATM,100.00,,", ",")
+	resubmitted, err := p.ResubmitFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resubmitted.Inserted != 1 || resubmitted.Duplicates != 2 || len(db.txns) != 3 {
+		t.Fatalf("resubmitted %+v, %d rows stored", resubmitted, len(db.txns))
+	}
+
+	// Resubmitting again changes nothing.
+	if resubmitted, err = p.ResubmitFile(file); err != nil || resubmitted.Inserted != 0 {
+		t.Fatalf("second resubmit: %+v, %v", resubmitted, err)
+	}
+}
+
+func TestAbortQuarantinesWholeFile(t *testing.T) {
+	viper.Set("ROW_ERROR_POLICY", PolicyAbort)
+	defer viper.Set("ROW_ERROR_POLICY", nil)
+
+	db := newMemDB()
+	p := NewProcessor(db)
+	_, err := p.IngestFile(context.Background(), IngestTask{Path: writeStatement(t, "hdfc.csv", badStatement), AccountID: "hdfc"})
+	if abort, ok := err.(*AbortError); !ok || abort.Line != 3 {
+		t.Fatalf("err = %v", err)
+	}
+	if len(db.txns) != 0 || len(db.files) != 1 || len(db.files[0].Records) != 3 {
+		t.Fatalf("%d rows stored, quarantined files %+v", len(db.txns), db.files)
+	}
+}
+
+func TestIngestFileCancelled(t *testing.T) {
+	db := newMemDB()
+	p := NewProcessor(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	statement := writeStatement(t, "hdfc.csv", "Date,Description,Debit,Credit,Balance\n05/08/2023,ATM,100.00,,900.00\n")
+	if _, err := p.IngestFile(ctx, IngestTask{Path: statement, AccountID: "hdfc"}); err != context.Canceled {
+		t.Fatalf("err = %v", err)
+	}
+	if err := p.processCSVFile(ctx, IngestTask{Path: statement, AccountID: "hdfc"}, &IngestReport{}); err != context.Canceled {
+		t.Fatalf("reading while cancelled: err = %v", err)
+	}
+	if len(db.txns) != 0 || len(db.files) != 0 {
+		t.Fatalf("%d rows stored, quarantined files %+v", len(db.txns), db.files)
+	}
+}
@@ -0,0 +1,307 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWSHeader is the header ReBIT uses to carry the detached signature of a
+// request or response body.
+const JWSHeader = "x-jws-signature"
+
+const maxSignedBody = 10 << 20
+
+type jwsHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid,omitempty"`
+	B64  *bool    `json:"b64,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// JWSSigner produces detached RS256 signatures with our private key.
+type JWSSigner struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+// NewJWSSigner loads an RSA private key from a PEM file (PKCS#1 or PKCS#8).
+func NewJWSSigner(keyFile, kid string) (*JWSSigner, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key: %v", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", keyFile)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return &JWSSigner{key: key, kid: kid}, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an RSA key", keyFile)
+	}
+	return &JWSSigner{key: key, kid: kid}, nil
+}
+
+// Sign returns the detached JWS of payload, i.e. "header..signature".
+func (s *JWSSigner) Sign(payload []byte) (string, error) {
+	header, err := json.Marshal(jwsHeader{Alg: "RS256", Kid: s.kid})
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+
+	digest := sha256.Sum256([]byte(encodedHeader + "." + base64.RawURLEncoding.EncodeToString(payload)))
+	signature, err := rsa.SignPKCS1v15(nil, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing payload: %v", err)
+	}
+	return encodedHeader + ".." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// JWSVerifier checks detached signatures against the public keys of a JWKS.
+type JWSVerifier struct {
+	keys map[string]*rsa.PublicKey
+}
+
+// LoadJWKS reads the RSA signing keys of a JWKS file.
+func LoadJWKS(path string) (*JWSVerifier, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JWKS: %v", err)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, fmt.Errorf("error decoding JWKS %s: %v", path, err)
+	}
+
+	verifier := &JWSVerifier{keys: make(map[string]*rsa.PublicKey)}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for key %q: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for key %q: %v", k.Kid, err)
+		}
+		verifier.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(verifier.keys) == 0 {
+		return nil, fmt.Errorf("JWKS %s has no RSA signing keys", path)
+	}
+	return verifier, nil
+}
+
+// Verify checks the detached signature of payload. Both the standard
+// encoding and the unencoded payload option (b64 false, RFC 7797) are accepted.
+func (v *JWSVerifier) Verify(signature string, payload []byte) error {
+	parts := strings.Split(signature, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return fmt.Errorf("signature is not a detached JWS")
+	}
+
+	header, err := decodeJWSHeader(parts[0])
+	if err != nil {
+		return err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(payload)
+	if header.B64 != nil && !*header.B64 {
+		signed = string(payload)
+	}
+	return v.verify(header, parts[0]+"."+signed, parts[2])
+}
+
+// VerifyCompact checks a compact JWS, such as a signed consent artefact, and
+// returns its payload.
+func (v *JWSVerifier) VerifyCompact(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[1] == "" {
+		return nil, fmt.Errorf("token is not a compact JWS")
+	}
+
+	header, err := decodeJWSHeader(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := v.verify(header, parts[0]+"."+parts[1], parts[2]); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding JWS payload: %v", err)
+	}
+	return payload, nil
+}
+
+func (v *JWSVerifier) verify(header jwsHeader, signingInput, signature string) error {
+	if header.Alg != "RS256" {
+		return fmt.Errorf("unsupported JWS alg %q", header.Alg)
+	}
+
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		if header.Kid != "" || len(v.keys) != 1 {
+			return fmt.Errorf("unknown JWS key %q", header.Kid)
+		}
+		for _, only := range v.keys {
+			key = only
+		}
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("error decoding JWS signature: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return fmt.Errorf("JWS signature does not match payload")
+	}
+	return nil
+}
+
+// JWSAlg returns the alg of a JWS header without checking its signature.
+func JWSAlg(token string) (string, error) {
+	header, err := decodeJWSHeader(strings.SplitN(token, ".", 2)[0])
+	if err != nil {
+		return "", err
+	}
+	return header.Alg, nil
+}
+
+func decodeJWSHeader(encoded string) (jwsHeader, error) {
+	var header jwsHeader
+	rawHeader, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return header, fmt.Errorf("error decoding JWS header: %v", err)
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return header, fmt.Errorf("error decoding JWS header: %v", err)
+	}
+	return header, nil
+}
+
+// SigningTransport signs the body of every outbound request when a signer is
+// set and, when a verifier is set, rejects responses whose signature does not
+// check out.
+type SigningTransport struct {
+	Signer   *JWSSigner
+	Verifier *JWSVerifier
+	Base     http.RoundTripper
+}
+
+func (t *SigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	signedReq := req.Clone(req.Context())
+	signedReq.Body = io.NopCloser(bytes.NewReader(body))
+	signedReq.ContentLength = int64(len(body))
+	if t.Signer != nil {
+		signature, err := t.Signer.Sign(body)
+		if err != nil {
+			return nil, err
+		}
+		signedReq.Header.Set(JWSHeader, signature)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(signedReq)
+	if err != nil || t.Verifier == nil {
+		return resp, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Verifier.Verify(resp.Header.Get(JWSHeader), respBody); err != nil {
+		return nil, fmt.Errorf("error verifying response of %s: %v", req.URL.Path, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// VerifyJWS rejects requests whose body does not match their x-jws-signature.
+func VerifyJWS(verifier *JWSVerifier) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBody))
+			if err != nil {
+				writeJWSError(w, http.StatusRequestEntityTooLarge, "PayloadTooLarge", "request body too large")
+				return
+			}
+
+			signature := r.Header.Get(JWSHeader)
+			if signature == "" {
+				writeJWSError(w, http.StatusBadRequest, "SignatureMissing", "missing "+JWSHeader+" header")
+				return
+			}
+			if err := verifier.Verify(signature, body); err != nil {
+				writeJWSError(w, http.StatusBadRequest, "SignatureDoesNotMatch", err.Error())
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeJWSError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"ver":       "1.1.2",
+		"timestamp": time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		"errorCode": code,
+		"errorMsg":  msg,
+	})
+}
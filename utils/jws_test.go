@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newJWSPair writes a fresh RSA key as a PKCS#8 PEM file and its public half
+// as a JWKS file, and loads both.
+func newJWSPair(t *testing.T, kid string) (*JWSSigner, *JWSVerifier) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "EC", "kid": "other"},
+		{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewJWSSigner(keyFile, kid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := LoadJWKS(jwksFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, verifier
+}
+
+func TestJWSSignVerify(t *testing.T) {
+	signer, verifier := newJWSPair(t, "k1")
+	payload := []byte(`{"ver":"1.1.2"}`)
+
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := strings.Split(signature, "."); len(parts) != 3 || parts[1] != "" {
+		t.Fatalf("signature %q is not detached", signature)
+	}
+	if err := verifier.Verify(signature, payload); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := verifier.Verify(signature, []byte(`{"ver":"1.1.3"}`)); err == nil {
+		t.Error("signature verified another payload")
+	}
+
+	_, other := newJWSPair(t, "k1")
+	if err := other.Verify(signature, payload); err == nil {
+		t.Error("signature verified with another key")
+	}
+}
+
+func TestJWSVerifyRejects(t *testing.T) {
+	signer, verifier := newJWSPair(t, "k1")
+	payload := []byte("{}")
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(signature, ".")
+
+	header := func(h string) string { return base64.RawURLEncoding.EncodeToString([]byte(h)) }
+	for name, sig := range map[string]string{
+		"empty":           "",
+		"attached":        parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2],
+		"alg none":        header(`{"alg":"none","kid":"k1"}`) + ".." + parts[2],
+		"unknown key":     header(`{"alg":"RS256","kid":"k2"}`) + ".." + parts[2],
+		"bad signature":   parts[0] + "..!!",
+		"header mismatch": header(`{"alg":"RS256","kid":"k1","b64":false,"crit":["b64"]}`) + ".." + parts[2],
+	} {
+		if err := verifier.Verify(sig, payload); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestSigningTransport(t *testing.T) {
+	fiuSigner, fiuVerifier := newJWSPair(t, "fiu")
+	aaSigner, aaVerifier := newJWSPair(t, "aa")
+
+	forged, requireSignature := false, true
+	aa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := fiuVerifier.Verify(r.Header.Get(JWSHeader), body); requireSignature && err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response := []byte(`{"status":"OK"}`)
+		signature, _ := aaSigner.Sign(response)
+		if forged {
+			response = []byte(`{"status":"FORGED"}`)
+		}
+		w.Header().Set(JWSHeader, signature)
+		w.Write(response)
+	}))
+	defer aa.Close()
+
+	client := &http.Client{Transport: &SigningTransport{Signer: fiuSigner, Verifier: aaVerifier}}
+	resp, err := client.Post(aa.URL+"/Consent", "application/json", strings.NewReader(`{"ver":"1.1.2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != `{"status":"OK"}` {
+		t.Fatalf("got %d %s", resp.StatusCode, body)
+	}
+
+	forged = true
+	if _, err := client.Post(aa.URL+"/Consent", "application/json", strings.NewReader("{}")); err == nil {
+		t.Error("forged response accepted")
+	}
+
+	// Without a signing key, responses are still verified.
+	requireSignature = false
+	verifyOnly := &http.Client{Transport: &SigningTransport{Verifier: aaVerifier}}
+	if _, err := verifyOnly.Get(aa.URL + "/Consent/handle"); err == nil {
+		t.Error("forged response accepted without a signer")
+	}
+	forged = false
+	resp, err = verifyOnly.Get(aa.URL + "/Consent/handle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestVerifyJWSMiddleware(t *testing.T) {
+	signer, verifier := newJWSPair(t, "aa")
+	handler := ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}), VerifyJWS(verifier))
+
+	payload := `{"ver":"1.1.2"}`
+	signature, err := signer.Sign([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		signature, body string
+		status          int
+	}{
+		"signed":    {signature, payload, http.StatusOK},
+		"unsigned":  {"", payload, http.StatusBadRequest},
+		"tampered":  {signature, `{"ver":"1.1.3"}`, http.StatusBadRequest},
+		"malformed": {"abc", payload, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/Consent/Notification", strings.NewReader(test.body))
+		if test.signature != "" {
+			req.Header.Set(JWSHeader, test.signature)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: status %d, want %d", name, rec.Code, test.status)
+		}
+		if test.status == http.StatusOK && rec.Body.String() != payload {
+			t.Errorf("%s: handler read %q", name, rec.Body.String())
+		}
+	}
+}
+
+func TestJWSVerifyCompact(t *testing.T) {
+	signer, verifier := newJWSPair(t, "aa")
+	payload := []byte(`{"consentMode":"STORE"}`)
+
+	detached, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(detached, ".")
+	token := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	got, err := verifier.VerifyCompact(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(payload) {
+		t.Errorf("payload = %s", got)
+	}
+
+	if alg, err := JWSAlg(token); err != nil || alg != "RS256" {
+		t.Errorf("JWSAlg = %q, %v", alg, err)
+	}
+
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"consentMode":"QUERY"}`)) + "." + parts[2]
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	for name, token := range map[string]string{"forged": forged, "unsigned": unsigned, "detached": detached} {
+		if _, err := verifier.VerifyCompact(token); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
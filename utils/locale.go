@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateTimeLayouts are the timestamp forms exports use for the date column.
+var dateTimeLayouts = []string{
+	"02/01/2006 15:04:05", "02/01/2006 15:04", "02-01-2006 15:04:05", "02-Jan-2006 15:04:05",
+	"2006-01-02 15:04:05", time.RFC3339,
+}
+
+var (
+	plainAmount = regexp.MustCompile(`^([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+	monthName   = regexp.MustCompile(`[A-Za-z]{3,}`)
+)
+
+// ParseAmount reads an amount the way Indian bank exports print it: grouped
+// in lakhs or thousands ("1,00,000.00"), with or without a rupee sign, with a
+// Dr or Cr marker ("5,000.00 Dr") and with a leading or trailing minus or
+// parentheses for negatives. Dr makes the amount negative; marked reports
+// whether a Dr or Cr marker set the sign.
+func ParseAmount(s string) (amount float64, marked bool, err error) {
+	value := strings.TrimSpace(strings.ReplaceAll(s, "\u00a0", " "))
+	negative, debit := false, false
+
+	upper := strings.ToUpper(value)
+	for _, marker := range []string{"DR", "CR"} {
+		switch {
+		case strings.HasSuffix(upper, marker+"."):
+			value = value[:len(value)-3]
+		case strings.HasSuffix(upper, marker):
+			value = value[:len(value)-2]
+		case strings.HasPrefix(upper, marker+" "), strings.HasPrefix(upper, marker+"."):
+			value = value[3:]
+		default:
+			continue
+		}
+		marked, debit = true, marker == "DR"
+		break
+	}
+	value = strings.TrimSpace(value)
+
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		value = strings.TrimSpace(value[1 : len(value)-1])
+		negative = !negative
+	}
+	for _, currency := range []string{"₹", "Rs.", "Rs", "INR"} {
+		if len(value) >= len(currency) && strings.EqualFold(value[:len(currency)], currency) {
+			value = strings.TrimSpace(value[len(currency):])
+			break
+		}
+	}
+	switch {
+	case strings.HasPrefix(value, "-"):
+		value = value[1:]
+		negative = !negative
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	case strings.HasSuffix(value, "-"):
+		value = value[:len(value)-1]
+		negative = !negative
+	}
+
+	digits := strings.NewReplacer(",", "", " ", "").Replace(value)
+	if !plainAmount.MatchString(digits) {
+		return 0, false, fmt.Errorf("%q is not an amount", s)
+	}
+	amount, err = strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%q is not an amount", s)
+	}
+	// A marker wins over any minus or parentheses printed with it.
+	if marked {
+		negative = debit
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, marked, nil
+}
+
+// ParseDate reads a date in any of the layouts Indian bank exports use, day
+// first when ambiguous, e.g. 02-Jan-2023, 2023-01-02 or 02/01/23.
+func ParseDate(value string) (time.Time, error) {
+	layouts := make([]string, 0, len(candidateDateLayouts)+len(dateTimeLayouts))
+	layouts = append(layouts, candidateDateLayouts...)
+	return parseDate(value, append(layouts, dateTimeLayouts...))
+}
+
+// normaliseMonths title-cases month names, which exports often print as
+// JAN or jan where time.Parse only accepts Jan, and shortens Sept to Sep.
+func normaliseMonths(value string) string {
+	return monthName.ReplaceAllStringFunc(value, func(word string) string {
+		word = strings.ToUpper(word[:1]) + strings.ToLower(word[1:])
+		if word == "Sept" {
+			return "Sep"
+		}
+		return word
+	})
+}
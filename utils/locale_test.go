@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, test := range []struct {
+		in     string
+		want   float64
+		marked bool
+	}{
+		{"1,00,000.00", 100000, false},
+		{"12,34,567.89", 1234567.89, false},
+		{"1,234.50", 1234.5, false},
+		{"5,000.00 Dr", -5000, true},
+		{"5,000.00 Cr", 5000, true},
+		{"5,000.00DR.", -5000, true},
+		{"Dr 250", -250, true},
+		{"(1,500.00)", -1500, false},
+		{"1,500.00-", -1500, false},
+		{"-1,500.00", -1500, false},
+		{"+75", 75, false},
+		{"₹1,00,000", 100000, false},
+		{"₹ 2,500.00", 2500, false},
+		{"Rs. 2,500.00", 2500, false},
+		{"Rs 99", 99, false},
+		{"INR 10", 10, false},
+		{"(₹ 1,000.00) Cr", 1000, true},
+		{"1 000.00", 1000, false},
+	} {
+		got, marked, err := ParseAmount(test.in)
+		if err != nil {
+			t.Errorf("ParseAmount(%q): %v", test.in, err)
+			continue
+		}
+		if got != test.want || marked != test.marked {
+			t.Errorf("ParseAmount(%q) = %v, %v, want %v, %v", test.in, got, marked, test.want, test.marked)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "1.2.3", "Dr", "₹", "12-34"} {
+		if _, _, err := ParseAmount(in); err == nil {
+			t.Errorf("ParseAmount(%q): no error", in)
+		}
+	}
+}
+
+func TestProcessDataHoldsProfileDateFormats(t *testing.T) {
+	p := &Processor{}
+	record := []string{"2023-08-05", "ATM", "100.00"}
+	cols := &columnMap{date: 0, description: 1, debit: 2, credit: -1, balance: -1, amount: -1}
+
+	cols.profile = &StatementProfile{DateFormats: []string{"02/01/2006"}, SignConvention: SignSplit}
+	if _, err := p.processData([]string{"05-Aug-2023", "ATM", "100.00"}, cols, "hdfc"); err == nil {
+		t.Error("date outside the profile's layouts accepted")
+	}
+
+	cols.profile = &StatementProfile{SignConvention: SignSplit}
+	got, err := p.processData([]string{"05-Aug-2023", "ATM", "100.00"}, cols, "hdfc")
+	if err != nil || got.Date != "2023-08-05" {
+		t.Errorf("without layouts: %+v, %v", got, err)
+	}
+
+	// ISO dates are always accepted.
+	cols.profile = &StatementProfile{DateFormats: []string{"02/01/2006"}, SignConvention: SignSplit}
+	if got, err := p.processData(record, cols, "hdfc"); err != nil || got.Date != "2023-08-05" {
+		t.Errorf("ISO date: %+v, %v", got, err)
+	}
+}
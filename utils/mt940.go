@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"valyx/aggregator/types"
+)
+
+var (
+	mt940Tag       = regexp.MustCompile(`^:(\d{2}[A-Z]?):(.*)$`)
+	mt940Balance   = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})(\d+,\d*)$`)
+	mt940Statement = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|EC|ED|C|D)([A-Z])?(\d+,\d*)([NSF][A-Z0-9]{3})([^/]*)(?://(.*))?$`)
+	mt942Sum       = regexp.MustCompile(`^(\d+)([A-Z]{3})(\d+,\d*)$`)
+)
+
+type mt940Field struct {
+	tag   string
+	value string
+}
+
+// ParseMT940 reads SWIFT MT940 end-of-day statements and MT942 intraday
+// reports. A file may hold several messages. :61: lines become transactions
+// described by the :86: line that follows them, and each MT940 message must
+// reconcile from its :60F: opening to its :62F: closing balance.
+func ParseMT940(r io.Reader) ([]types.Transaction, error) {
+	messages, err := splitMT940Messages(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no MT940/MT942 message found")
+	}
+
+	var transactions []types.Transaction
+	for _, fields := range messages {
+		parsed, err := parseMT940Message(fields)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, parsed...)
+	}
+	return transactions, nil
+}
+
+// splitMT940Messages groups tagged fields into messages, each starting at
+// :20:. Continuation lines are folded into the preceding field and SWIFT
+// block wrappers are ignored.
+func splitMT940Messages(r io.Reader) ([][]mt940Field, error) {
+	var messages [][]mt940Field
+	var current []mt940Field
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r ")
+		if line == "" || line == "-" || line == "-}" || strings.HasPrefix(line, "{") {
+			if idx := strings.Index(line, "{4:"); idx >= 0 && strings.TrimSpace(line[idx+3:]) != "" {
+				line = line[idx+3:]
+			} else {
+				continue
+			}
+		}
+
+		if match := mt940Tag.FindStringSubmatch(line); match != nil {
+			if match[1] == "20" && len(current) > 0 {
+				messages = append(messages, current)
+				current = nil
+			}
+			current = append(current, mt940Field{tag: match[1], value: match[2]})
+			continue
+		}
+		if len(current) > 0 {
+			current[len(current)-1].value += "\n" + line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current) > 0 {
+		messages = append(messages, current)
+	}
+	return messages, nil
+}
+
+func parseMT940Message(fields []mt940Field) ([]types.Transaction, error) {
+	var (
+		accountId    string
+		reference    string
+		transactions []types.Transaction
+		opening      *float64
+		closing      *float64
+		sumDebits    *float64
+		sumCredits   *float64
+		lastIsLine   bool
+	)
+
+	for _, field := range fields {
+		switch field.tag {
+		case "20":
+			reference = strings.TrimSpace(field.value)
+		case "25":
+			accountId = strings.TrimSpace(field.value)
+		case "60F", "60M":
+			amount, err := parseMT940Balance(field.value)
+			if err != nil {
+				return nil, fmt.Errorf("statement %s: error parsing :%s: %v", reference, field.tag, err)
+			}
+			opening = &amount
+		case "62F", "62M":
+			amount, err := parseMT940Balance(field.value)
+			if err != nil {
+				return nil, fmt.Errorf("statement %s: error parsing :%s: %v", reference, field.tag, err)
+			}
+			closing = &amount
+		case "90D", "90C":
+			match := mt942Sum.FindStringSubmatch(strings.TrimSpace(field.value))
+			if match == nil {
+				return nil, fmt.Errorf("statement %s: invalid :%s: %q", reference, field.tag, field.value)
+			}
+			amount, _ := parseMT940Amount(match[3])
+			if field.tag == "90D" {
+				sumDebits = &amount
+			} else {
+				sumCredits = &amount
+			}
+		case "61":
+			t, err := parseMT940Line(field.value)
+			if err != nil {
+				return nil, fmt.Errorf("statement %s: %v", reference, err)
+			}
+			transactions = append(transactions, t)
+			lastIsLine = true
+			continue
+		case "86":
+			if lastIsLine {
+				narrative := strings.Join(strings.Fields(field.value), " ")
+				transactions[len(transactions)-1].Description = narrative
+			}
+		}
+		lastIsLine = false
+	}
+
+	if accountId == "" {
+		return nil, fmt.Errorf("statement %s has no :25: account", reference)
+	}
+
+	var debits, credits float64
+	for i := range transactions {
+		transactions[i].AccountID = accountId
+		debits += transactions[i].Debit.Float64
+		credits += transactions[i].Credit.Float64
+	}
+
+	if sumDebits != nil && !amountsEqual(*sumDebits, debits) {
+		return nil, fmt.Errorf("statement %s: :90D: total %.2f does not match debits %.2f", reference, *sumDebits, debits)
+	}
+	if sumCredits != nil && !amountsEqual(*sumCredits, credits) {
+		return nil, fmt.Errorf("statement %s: :90C: total %.2f does not match credits %.2f", reference, *sumCredits, credits)
+	}
+
+	if opening != nil {
+		running := *opening
+		for i := range transactions {
+			running += transactions[i].Credit.Float64 - transactions[i].Debit.Float64
+			transactions[i].Balance = sql.NullFloat64{Float64: math.Round(running*100) / 100, Valid: true}
+		}
+		if closing != nil && !amountsEqual(running, *closing) {
+			return nil, fmt.Errorf("statement %s: opening balance %.2f plus movements gives %.2f, closing balance is %.2f", reference, *opening, running, *closing)
+		}
+	} else if closing != nil {
+		fillBalancesBackwards(transactions, *closing)
+	}
+
+	return transactions, nil
+}
+
+// parseMT940Line decodes a :61: statement line, for example
+// 2308050805D1500,00NTRFNONREF//HDFC123456.
+func parseMT940Line(value string) (types.Transaction, error) {
+	lines := strings.SplitN(value, "\n", 2)
+	match := mt940Statement.FindStringSubmatch(strings.TrimSpace(lines[0]))
+	if match == nil {
+		return types.Transaction{}, fmt.Errorf("invalid :61: line %q", lines[0])
+	}
+
+	date, err := time.Parse("060102", match[1])
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("invalid value date in :61: line %q", lines[0])
+	}
+	if match[2] != "" {
+		// The entry (booking) date has no year, take it from the value date
+		// and allow for statements straddling new year.
+		entry, err := time.Parse("0102", match[2])
+		if err == nil {
+			booked := time.Date(date.Year(), entry.Month(), entry.Day(), 0, 0, 0, 0, time.UTC)
+			if booked.Sub(date) > 180*24*time.Hour {
+				booked = booked.AddDate(-1, 0, 0)
+			} else if date.Sub(booked) > 180*24*time.Hour {
+				booked = booked.AddDate(1, 0, 0)
+			}
+			date = booked
+		}
+	}
+
+	amount, err := parseMT940Amount(match[5])
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("invalid amount in :61: line %q", lines[0])
+	}
+
+	t := types.Transaction{Date: date.Format("2006-01-02")}
+	switch match[3] {
+	case "D", "ED", "RC":
+		t.Debit = sql.NullFloat64{Float64: amount, Valid: true}
+	default:
+		t.Credit = sql.NullFloat64{Float64: amount, Valid: true}
+	}
+
+	customerRef := strings.TrimSpace(match[7])
+	bankRef := strings.TrimSpace(match[8])
+	// Only the bank's reference identifies the transaction; the customer's
+	// may repeat, e.g. an invoice number paid in two parts.
+	t.ExternalID = bankRef
+	if customerRef != "NONREF" {
+		t.Reference = customerRef
+	}
+
+	if len(lines) > 1 {
+		t.Description = strings.TrimSpace(lines[1])
+	}
+	if t.Description == "" {
+		t.Description = match[6]
+	}
+	return t, nil
+}
+
+func parseMT940Balance(value string) (float64, error) {
+	match := mt940Balance.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, fmt.Errorf("invalid balance %q", value)
+	}
+	amount, err := parseMT940Amount(match[4])
+	if err != nil {
+		return 0, err
+	}
+	if match[1] == "D" {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// parseMT940Amount parses SWIFT amounts, which use a comma as the decimal
+// separator and may end with it ("1500,").
+func parseMT940Amount(value string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSuffix(strings.Replace(value, ",", ".", 1), "."), 64)
+}
+
+func amountsEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
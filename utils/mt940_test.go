@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"valyx/aggregator/types"
+)
+
+// movements writes each transaction as "date ±amount =balance", leaving out
+// the balance when there is none.
+func movements(transactions []types.Transaction) []string {
+	var lines []string
+	for _, t := range transactions {
+		line := fmt.Sprintf("%s %+.2f", t.Date, net(t))
+		if t.Balance.Valid {
+			line += fmt.Sprintf(" =%.2f", t.Balance.Float64)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestParseMT940(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			name: "balances run forward from 60F and reconcile with 62F",
+			doc: `{1:F01HDFCINBBAXXX0000000000}{2:O9400000230901HDFCINBBAXXX00000000002309010000N}{4:
+:20:STMT0801
+:25:50100123451234
+:60F:C230801INR1000000,00
+:61:2308050805C6361,88NTRFNONREF//HDFC0001
+:61:2308060806D4619,46NTRFINV-42//HDFC0002
+:62F:C230806INR1001742,42
+-}`,
+			want: []string{"2023-08-05 +6361.88 =1006361.88", "2023-08-06 -4619.46 =1001742.42"},
+		},
+		{
+			name: "debit opening balance",
+			doc:  ":20:S1\n:25:1\n:60F:D230801INR100,\n:61:230805C150,NTRFNONREF//B1\n:62F:C230805INR50,\n",
+			want: []string{"2023-08-05 +150.00 =50.00"},
+		},
+		{
+			name: "62F alone fills balances backwards",
+			doc:  ":20:S1\n:25:1\n:61:230805C10,NTRFNONREF//B1\n:61:230806D4,NTRFNONREF//B2\n:62F:C230806INR106,\n",
+			want: []string{"2023-08-05 +10.00 =110.00", "2023-08-06 -4.00 =106.00"},
+		},
+		{
+			name: "MT942 checks 90D and 90C but has no balances",
+			doc:  ":20:INTRA1\n:25:1\n:34F:INRD0,\n:61:230807D250,00NTRFNONREF//B3\n:61:230807C20,NTRFNONREF//B4\n:90D:1INR250,00\n:90C:1INR20,\n",
+			want: []string{"2023-08-07 -250.00", "2023-08-07 +20.00"},
+		},
+		{
+			name: "reversal of a credit is a debit",
+			doc:  ":20:S1\n:25:1\n:61:230805RC10,NTRFNONREF//B1\n",
+			want: []string{"2023-08-05 -10.00"},
+		},
+		{
+			name: "entry date in the next year",
+			doc:  ":20:S1\n:25:1\n:61:2312310102D5,NTRFNONREF//B1\n",
+			want: []string{"2024-01-02 -5.00"},
+		},
+		{
+			name: "several messages",
+			doc:  ":20:S1\n:25:1\n:61:230805C1,NTRFNONREF//B1\n-\n:20:S2\n:25:2\n:61:230806C2,NTRFNONREF//B2\n",
+			want: []string{"2023-08-05 +1.00", "2023-08-06 +2.00"},
+		},
+	} {
+		got, err := ParseMT940(strings.NewReader(test.doc))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if lines := movements(got); !reflect.DeepEqual(lines, test.want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", test.name, lines, test.want)
+		}
+	}
+}
+
+func TestParseMT940References(t *testing.T) {
+	doc := `:20:S1
+:25:50100123451234
+:61:2308050805C6361,88NTRFNONREF//HDFC0001
+:86:NEFTIN FROM ACME
+ CORP
+:61:2308060806D4619,46NTRFINV-42//HDFC0002
+:61:230807D1,NCHG
+`
+	got, err := ParseMT940(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.Transaction{
+		// The :86: narrative describes the line before it, folded onto one line.
+		{AccountID: "50100123451234", Date: "2023-08-05", Description: "NEFTIN FROM ACME CORP", Credit: amount(6361.88), ExternalID: "HDFC0001"},
+		// The bank reference identifies the entry; the customer's is kept
+		// as the reference unless it is NONREF.
+		{AccountID: "50100123451234", Date: "2023-08-06", Description: "NTRF", Debit: amount(4619.46), ExternalID: "HDFC0002", Reference: "INV-42"},
+		{AccountID: "50100123451234", Date: "2023-08-07", Description: "NCHG", Debit: amount(1)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMT940:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseMT940Errors(t *testing.T) {
+	for _, test := range []struct {
+		name, doc, reason string
+	}{
+		{"empty", "", "no MT940/MT942 message"},
+		{"no account", ":20:S1\n:60F:C230801INR0,\n:61:230805C10,NTRFNONREF\n", "has no :25: account"},
+		{"60F to 62F mismatch", ":20:S1\n:25:1\n:60F:C230801INR100,00\n:61:230805C10,NTRFNONREF\n:62F:C230805INR100,00\n", "closing balance is 100.00"},
+		{"90D mismatch", ":20:S1\n:25:1\n:61:230805D10,NTRFNONREF\n:90D:1INR11,00\n", ":90D: total 11.00 does not match debits 10.00"},
+		{"90C mismatch", ":20:S1\n:25:1\n:61:230805C10,NTRFNONREF\n:90C:1INR9,\n", ":90C: total 9.00 does not match credits 10.00"},
+		{"bad 90D", ":20:S1\n:25:1\n:90D:INR1,\n", "invalid :90D:"},
+		{"bad balance", ":20:S1\n:25:1\n:60F:X230801INR1,\n", "error parsing :60F:"},
+		{"bad line", ":20:S1\n:25:1\n:61:2308X5C10,NTRFNONREF\n", "invalid :61: line"},
+	} {
+		_, err := ParseMT940(strings.NewReader(test.doc))
+		if err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: err = %v, want %q", test.name, err, test.reason)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"valyx/aggregator/types"
+)
+
+// ofxNode is one element of an OFX document. OFX 1.x is SGML where leaf
+// elements have no closing tag, so both versions are read into this tree
+// instead of going through encoding/xml.
+type ofxNode struct {
+	name     string
+	value    string
+	children []*ofxNode
+}
+
+var ofxEntities = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ")
+
+// ParseOFX reads an OFX 1.x (SGML) or 2.x (XML) bank or credit card statement,
+// QFX included. Every transaction keeps its FITID as ExternalID and running
+// balances are rebuilt backwards from the LEDGERBAL checkpoint.
+func ParseOFX(r io.Reader) ([]types.Transaction, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text, _, err := decodeText(content)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseOFXTree(text)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := root.findAll("STMTRS")
+	statements = append(statements, root.findAll("CCSTMTRS")...)
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("no statement found in OFX document")
+	}
+
+	var transactions []types.Transaction
+	for _, stmt := range statements {
+		parsed, err := parseOFXStatement(stmt)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, parsed...)
+	}
+	return transactions, nil
+}
+
+func parseOFXStatement(stmt *ofxNode) ([]types.Transaction, error) {
+	account := stmt.find("BANKACCTFROM")
+	if account == nil {
+		account = stmt.find("CCACCTFROM")
+	}
+	if account == nil || account.childValue("ACCTID") == "" {
+		return nil, fmt.Errorf("OFX statement has no account id")
+	}
+	accountId := account.childValue("ACCTID")
+
+	var transactions []types.Transaction
+	for _, trn := range stmt.findAll("STMTTRN") {
+		date, err := parseOFXDate(trn.childValue("DTPOSTED"))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing DTPOSTED of %s: %v", trn.childValue("FITID"), err)
+		}
+		amount, err := strconv.ParseFloat(trn.childValue("TRNAMT"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing TRNAMT of %s: %v", trn.childValue("FITID"), err)
+		}
+
+		t := types.Transaction{
+			AccountID:   accountId,
+			Date:        date.Format("2006-01-02"),
+			Description: joinNonEmpty(trn.childValue("NAME"), trn.childValue("MEMO")),
+			ExternalID:  trn.childValue("FITID"),
+		}
+		if amount < 0 {
+			t.Debit = sql.NullFloat64{Float64: -amount, Valid: true}
+		} else {
+			t.Credit = sql.NullFloat64{Float64: amount, Valid: true}
+		}
+		transactions = append(transactions, t)
+	}
+
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Date < transactions[j].Date
+	})
+
+	if ledger := stmt.find("LEDGERBAL"); ledger != nil {
+		balance, err := strconv.ParseFloat(ledger.childValue("BALAMT"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing LEDGERBAL: %v", err)
+		}
+		fillBalancesBackwards(transactions, balance)
+	}
+	return transactions, nil
+}
+
+// fillBalancesBackwards sets the running balance of each transaction given the
+// balance after the last one.
+func fillBalancesBackwards(transactions []types.Transaction, closing float64) {
+	running := closing
+	for i := len(transactions) - 1; i >= 0; i-- {
+		transactions[i].Balance = sql.NullFloat64{Float64: math.Round(running*100) / 100, Valid: true}
+		running -= transactions[i].Credit.Float64 - transactions[i].Debit.Float64
+	}
+}
+
+// parseOFXDate accepts the OFX datetime format, e.g. 20230805,
+// 20230805120000 or 20230805120000.000[+5.30:IST]. Only the date is kept.
+func parseOFXDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid OFX date %q", value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+func parseOFXTree(text string) (*ofxNode, error) {
+	start := strings.Index(strings.ToUpper(text), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("no <OFX> element found")
+	}
+	text = text[start:]
+
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	for len(text) > 0 {
+		open := strings.IndexByte(text, '<')
+		if open < 0 {
+			break
+		}
+
+		if value := strings.TrimSpace(text[:open]); value != "" && len(stack) > 1 {
+			top := stack[len(stack)-1]
+			top.value = ofxEntities.Replace(value)
+			// SGML leaves are closed implicitly by their value.
+			stack = stack[:len(stack)-1]
+		}
+
+		end := strings.IndexByte(text[open:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated tag in OFX document")
+		}
+		tag := strings.TrimSpace(text[open+1 : open+end])
+		text = text[open+end+1:]
+
+		switch {
+		case tag == "" || strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") || strings.HasSuffix(tag, "/"):
+			continue
+		case strings.HasPrefix(tag, "/"):
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		default:
+			if fields := strings.Fields(tag); len(fields) > 0 {
+				tag = fields[0]
+			}
+			node := &ofxNode{name: strings.ToUpper(tag)}
+			top := stack[len(stack)-1]
+			top.children = append(top.children, node)
+			stack = append(stack, node)
+		}
+	}
+
+	return root, nil
+}
+
+func (n *ofxNode) find(name string) *ofxNode {
+	for _, child := range n.children {
+		if child.name == name {
+			return child
+		}
+		if found := child.find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (n *ofxNode) findAll(name string) []*ofxNode {
+	var found []*ofxNode
+	for _, child := range n.children {
+		if child.name == name {
+			found = append(found, child)
+			continue
+		}
+		found = append(found, child.findAll(name)...)
+	}
+	return found
+}
+
+func (n *ofxNode) childValue(name string) string {
+	for _, child := range n.children {
+		if child.name == name {
+			return child.value
+		}
+	}
+	return ""
+}
+
+func joinNonEmpty(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part != "" && (len(kept) == 0 || kept[len(kept)-1] != part) {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, " ")
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"valyx/aggregator/types"
+)
+
+const ofxSGMLHeader = "OFXHEADER:100\nDATA:OFXSGML\nVERSION:102\nSECURITY:NONE\nENCODING:USASCII\nCHARSET:1252\n\n"
+
+func TestParseOFX(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			name: "SGML leaves without closing tags, sorted and rebuilt backwards from LEDGERBAL",
+			doc: ofxSGMLHeader + `<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<BANKACCTFROM><BANKID>HDFC0001234<ACCTID>50100123451234<ACCTTYPE>SAVINGS</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20230806120000.000[+5.30:IST]<TRNAMT>-4619.46<FITID>2</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20230805<TRNAMT>6361.88<FITID>1</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>1001742.42<DTASOF>20230831</LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>`,
+			want: []string{"2023-08-05 +6361.88 =1006361.88", "2023-08-06 -4619.46 =1001742.42"},
+		},
+		{
+			name: "overdrawn LEDGERBAL",
+			doc: `<OFX><STMTRS><BANKACCTFROM><ACCTID>1</BANKACCTFROM>
+<STMTTRN><DTPOSTED>20230801<TRNAMT>-30<FITID>1</STMTTRN>
+<STMTTRN><DTPOSTED>20230802<TRNAMT>10<FITID>2</STMTTRN>
+<LEDGERBAL><BALAMT>-5.00</LEDGERBAL></STMTRS></OFX>`,
+			want: []string{"2023-08-01 -30.00 =-15.00", "2023-08-02 +10.00 =-5.00"},
+		},
+		{
+			name: "XML credit card statement without LEDGERBAL",
+			doc: `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220"?>
+<OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>
+  <CCACCTFROM><ACCTID>4111XXXXXXXX1111</ACCTID></CCACCTFROM>
+  <BANKTRANLIST><STMTTRN><DTPOSTED>20230810</DTPOSTED><TRNAMT>-1499.00</TRNAMT><FITID>CC-1</FITID></STMTTRN></BANKTRANLIST>
+</CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>`,
+			want: []string{"2023-08-10 -1499.00"},
+		},
+		{
+			name: "bank and card statements in one file",
+			doc: `<OFX><STMTRS><BANKACCTFROM><ACCTID>1</BANKACCTFROM><STMTTRN><DTPOSTED>20230802<TRNAMT>1<FITID>1</STMTTRN></STMTRS>
+<CCSTMTRS><CCACCTFROM><ACCTID>2</CCACCTFROM><STMTTRN><DTPOSTED>20230801<TRNAMT>-2<FITID>2</STMTTRN></CCSTMTRS></OFX>`,
+			want: []string{"2023-08-02 +1.00", "2023-08-01 -2.00"},
+		},
+	} {
+		got, err := ParseOFX(strings.NewReader(test.doc))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if lines := movements(got); !reflect.DeepEqual(lines, test.want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", test.name, lines, test.want)
+		}
+	}
+}
+
+func TestParseOFXDescriptions(t *testing.T) {
+	doc := ofxSGMLHeader + `<OFX><STMTRS><BANKACCTFROM><ACCTID>50100123451234</BANKACCTFROM>
+<STMTTRN><DTPOSTED>20230805<TRNAMT>6361.88<FITID>20230805001<NAME>NEFTIN<MEMO>NEFTIN</STMTTRN>
+<STMTTRN><DTPOSTED>20230806<TRNAMT>-4619.46<FITID>20230806001<NAME>TRANSFER<MEMO>Rent &amp; maintenance</STMTTRN>
+<STMTTRN><DTPOSTED>20230807<TRNAMT>-1<FITID>20230807001<MEMO>SMS CHARGES</STMTTRN>
+</STMTRS></OFX>`
+
+	got, err := ParseOFX(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.Transaction{
+		// A MEMO repeating the NAME is not doubled.
+		{AccountID: "50100123451234", Date: "2023-08-05", Description: "NEFTIN", Credit: amount(6361.88), ExternalID: "20230805001"},
+		{AccountID: "50100123451234", Date: "2023-08-06", Description: "TRANSFER Rent & maintenance", Debit: amount(4619.46), ExternalID: "20230806001"},
+		{AccountID: "50100123451234", Date: "2023-08-07", Description: "SMS CHARGES", Debit: amount(1), ExternalID: "20230807001"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseOFX:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseOFXErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, doc, reason string
+	}{
+		{"no OFX element", "OFXHEADER:100\n", "no <OFX> element"},
+		{"unterminated tag", "<OFX><STMTRS", "unterminated tag"},
+		{"no statement", "<OFX><SIGNONMSGSRSV1></SIGNONMSGSRSV1></OFX>", "no statement found"},
+		{"no account", "<OFX><STMTRS><BANKTRANLIST></BANKTRANLIST></STMTRS></OFX>", "has no account id"},
+		{"bad amount", "<OFX><STMTRS><BANKACCTFROM><ACCTID>1</BANKACCTFROM><STMTTRN><DTPOSTED>20230801<TRNAMT>1,00<FITID>7</STMTTRN></STMTRS></OFX>", "error parsing TRNAMT of 7"},
+		{"bad date", "<OFX><STMTRS><BANKACCTFROM><ACCTID>1</BANKACCTFROM><STMTTRN><DTPOSTED>2023<TRNAMT>1<FITID>7</STMTTRN></STMTRS></OFX>", "error parsing DTPOSTED of 7"},
+		{"bad LEDGERBAL", "<OFX><STMTRS><BANKACCTFROM><ACCTID>1</BANKACCTFROM><LEDGERBAL><BALAMT>n/a</LEDGERBAL></STMTRS></OFX>", "error parsing LEDGERBAL"},
+	} {
+		_, err := ParseOFX(strings.NewReader(test.doc))
+		if err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: err = %v, want %q", test.name, err, test.reason)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// IngestTask is one statement file to import. AccountID and Profile force
+// the account and statement profile when set; Account supplies account
+// details the statement itself may not print. Source is set on files Expand
+// extracted, naming the archive member or email they came from.
+type IngestTask struct {
+	Path      string
+	AccountID string
+	Profile   string
+	Account   StatementAccount
+	Source    string
+}
+
+// IngestResult is the outcome of an IngestTask. Report is nil for files that
+// were never started because the import was cancelled.
+type IngestResult struct {
+	Task   IngestTask
+	Report *IngestReport
+	Err    error
+}
+
+// IngestFiles imports tasks on up to workers goroutines and returns their
+// results in the order of tasks. The files of one account never interleave:
+// those the task or file name assigns to an account are imported one at a
+// time in the order given, and those whose account only shows in their
+// header block wait for any other file of that account once it is read.
+// Cancelling ctx, or any failure under the abort policy, stops further files
+// from being started; files being read when ctx is cancelled are not stored.
+func (p *Processor) IngestFiles(ctx context.Context, tasks []IngestTask, workers int) []IngestResult {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each account's tasks form a queue handed to one worker as a whole.
+	var queues [][]int
+	queueOf := make(map[string]int)
+	for i, task := range tasks {
+		q, ok := queueOf[task.queueKey()]
+		if !ok {
+			q = len(queues)
+			queueOf[task.queueKey()] = q
+			queues = append(queues, nil)
+		}
+		queues[q] = append(queues[q], i)
+	}
+
+	abort := errorPolicy() == PolicyAbort
+	results := make([]IngestResult, len(tasks))
+	pending := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(queues); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for queue := range pending {
+				for _, i := range queue {
+					results[i] = p.ingestTask(ctx, tasks[i])
+					if abort && results[i].Err != nil {
+						cancel()
+					}
+				}
+			}
+		}()
+	}
+
+	for _, queue := range queues {
+		pending <- queue
+	}
+	close(pending)
+	wg.Wait()
+	return results
+}
+
+// queueKey is the account a task belongs to as far as is known before its
+// file is read.
+func (t IngestTask) queueKey() string {
+	return t.accountID(t.Account.merge(StatementAccount{}))
+}
+
+func (p *Processor) ingestTask(ctx context.Context, task IngestTask) IngestResult {
+	if err := ctx.Err(); err != nil {
+		return IngestResult{Task: task, Err: err}
+	}
+	report, err := p.IngestFile(ctx, task)
+	return IngestResult{Task: task, Report: report, Err: err}
+}
+
+// KeyLocks lets one holder of each key in at a time, such as one file of
+// each account being stored.
+type KeyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+// Lock waits for the locks of keys, taken in sorted order so that holders of
+// several keys cannot deadlock, and returns the function that releases them.
+func (l *KeyLocks) Lock(keys ...string) (unlock func()) {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	var held []string
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		l.mu.Lock()
+		if l.locks == nil {
+			l.locks = make(map[string]*keyLock)
+		}
+		lock, ok := l.locks[key]
+		if !ok {
+			lock = &keyLock{}
+			l.locks[key] = lock
+		}
+		lock.users++
+		l.mu.Unlock()
+
+		lock.Lock()
+		held = append(held, key)
+	}
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, key := range held {
+			lock := l.locks[key]
+			lock.Unlock()
+			if lock.users--; lock.users == 0 {
+				delete(l.locks, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// SignSplit means the statement has separate debit and credit columns.
+	SignSplit = "split"
+	// SignSigned means a single amount column where negative values are debits.
+	SignSigned = "signed"
+	// SignDebitPositive means a single amount column where positive values are
+	// debits, as seen on most card statements.
+	SignDebitPositive = "debit_positive"
+)
+
+// fingerprintScanRows bounds how far into a file we look for a header row
+// matching a profile fingerprint.
+const fingerprintScanRows = 25
+
+// ColumnRef points at a statement column either by header title or by
+// zero-based position. The header title wins when both are set.
+type ColumnRef struct {
+	Header string `mapstructure:"header"`
+	Index  *int   `mapstructure:"index"`
+}
+
+type ProfileColumns struct {
+	Date        ColumnRef `mapstructure:"date"`
+	Description ColumnRef `mapstructure:"description"`
+	Debit       ColumnRef `mapstructure:"debit"`
+	Credit      ColumnRef `mapstructure:"credit"`
+	Amount      ColumnRef `mapstructure:"amount"`
+	Balance     ColumnRef `mapstructure:"balance"`
+}
+
+// StatementProfile describes the layout of one bank's statement export.
+type StatementProfile struct {
+	Name           string         `mapstructure:"name"`
+	FilePattern    string         `mapstructure:"file_pattern"`
+	Fingerprint    []string       `mapstructure:"header_fingerprint"`
+	Delimiter      string         `mapstructure:"delimiter"`
+	SkipRows       int            `mapstructure:"skip_rows"`
+	NoHeader       bool           `mapstructure:"no_header"`
+	DateFormats    []string       `mapstructure:"date_formats"`
+	SignConvention string         `mapstructure:"sign_convention"`
+	Columns        ProfileColumns `mapstructure:"columns"`
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// LoadProfiles reads every .yaml, .yml and .json file in dir as a
+// StatementProfile. A missing directory leaves only automatic column mapping.
+func (p *Processor) LoadProfiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading profiles directory: %v", err)
+	}
+
+	var profiles []StatementProfile
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+
+		profile, err := loadProfile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, profile)
+	}
+
+	p.profiles = profiles
+	return nil
+}
+
+func loadProfile(path string) (StatementProfile, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return StatementProfile{}, fmt.Errorf("error reading profile %s: %v", path, err)
+	}
+
+	var profile StatementProfile
+	if err := v.Unmarshal(&profile); err != nil {
+		return StatementProfile{}, fmt.Errorf("error decoding profile %s: %v", path, err)
+	}
+	if profile.Name == "" {
+		profile.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if profile.SignConvention == "" {
+		profile.SignConvention = SignSplit
+	}
+	if profile.FilePattern == "" && len(profile.Fingerprint) == 0 {
+		return StatementProfile{}, fmt.Errorf("profile %s needs a file_pattern or header_fingerprint", profile.Name)
+	}
+	return profile, nil
+}
+
+// profileForFile returns the profile whose file pattern matches fileName, or
+// nil if none does.
+func (p *Processor) profileForFile(fileName string) *StatementProfile {
+	name := strings.ToLower(fileName)
+	for i := range p.profiles {
+		pattern := strings.ToLower(p.profiles[i].FilePattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return &p.profiles[i]
+		}
+	}
+	return nil
+}
+
+// profileByName returns the loaded profile called name, or nil.
+func (p *Processor) profileByName(name string) *StatementProfile {
+	for i := range p.profiles {
+		if strings.EqualFold(p.profiles[i].Name, name) {
+			return &p.profiles[i]
+		}
+	}
+	return nil
+}
+
+// HasProfile reports whether a statement profile called name is loaded.
+func (p *Processor) HasProfile(name string) bool {
+	return p.profileByName(name) != nil
+}
+
+// selectProfile picks the profile for a file: the one called name when given,
+// otherwise first by file name, then by header fingerprint and finally by
+// sniffing the columns. It returns the profile along with the index of its
+// header row (-1 when the file has no header).
+func (p *Processor) selectProfile(name string, fileName string, rows [][]string) (*StatementProfile, int, error) {
+	if name != "" {
+		profile := p.profileByName(name)
+		if profile == nil {
+			return nil, -1, fmt.Errorf("unknown statement profile %q", name)
+		}
+		return profile, profile.headerRow(rows), nil
+	}
+
+	if profile := p.profileForFile(fileName); profile != nil {
+		return profile, profile.headerRow(rows), nil
+	}
+
+	for i := range p.profiles {
+		profile := &p.profiles[i]
+		if len(profile.Fingerprint) == 0 {
+			continue
+		}
+		if idx := profile.fingerprintRow(rows); idx >= 0 {
+			return profile, idx, nil
+		}
+	}
+
+	return sniffProfile(fileName, rows)
+}
+
+func (sp *StatementProfile) headerRow(rows [][]string) int {
+	if sp.NoHeader {
+		return -1
+	}
+	if sp.SkipRows > 0 {
+		return sp.SkipRows
+	}
+	if len(sp.Fingerprint) > 0 {
+		if idx := sp.fingerprintRow(rows); idx >= 0 {
+			return idx
+		}
+	}
+	if idx := findHeaderRow(rows); idx >= 0 {
+		return idx
+	}
+	return 0
+}
+
+func (sp *StatementProfile) fingerprintRow(rows [][]string) int {
+	for i, row := range rows {
+		if i >= fingerprintScanRows {
+			break
+		}
+		matched := true
+		for _, title := range sp.Fingerprint {
+			if headerIndex(row, title) < 0 {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return i
+		}
+	}
+	return -1
+}
+
+// columnMap is a profile resolved against the header of a concrete file.
+type columnMap struct {
+	profile     *StatementProfile
+	date        int
+	description int
+	debit       int
+	credit      int
+	amount      int
+	balance     int
+}
+
+func (sp *StatementProfile) resolve(header []string) (*columnMap, error) {
+	cols := &columnMap{
+		profile:     sp,
+		date:        sp.Columns.Date.resolve(header),
+		description: sp.Columns.Description.resolve(header),
+		debit:       sp.Columns.Debit.resolve(header),
+		credit:      sp.Columns.Credit.resolve(header),
+		amount:      sp.Columns.Amount.resolve(header),
+		balance:     sp.Columns.Balance.resolve(header),
+	}
+
+	if cols.date < 0 {
+		return nil, fmt.Errorf("profile %s: date column not found", sp.Name)
+	}
+	switch sp.SignConvention {
+	case SignSplit:
+		if cols.debit < 0 && cols.credit < 0 {
+			return nil, fmt.Errorf("profile %s: debit and credit columns not found", sp.Name)
+		}
+	case SignSigned, SignDebitPositive:
+		if cols.amount < 0 {
+			return nil, fmt.Errorf("profile %s: amount column not found", sp.Name)
+		}
+	default:
+		return nil, fmt.Errorf("profile %s: unknown sign convention %q", sp.Name, sp.SignConvention)
+	}
+	return cols, nil
+}
+
+func (c ColumnRef) resolve(header []string) int {
+	if c.Header != "" {
+		return headerIndex(header, c.Header)
+	}
+	if c.Index != nil {
+		return *c.Index
+	}
+	return -1
+}
+
+func headerIndex(header []string, title string) int {
+	for i, cell := range header {
+		if strings.EqualFold(strings.TrimSpace(cell), strings.TrimSpace(title)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// cell returns the trimmed value at idx, or "" if the column is unmapped or
+// missing from a short row.
+func cell(record []string, idx int) string {
+	if idx < 0 || idx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[idx])
+}
@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"valyx/aggregator/types"
+)
+
+// flexString accepts both JSON strings and numbers, since FIPs are not
+// consistent about quoting amounts in the ReBIT JSON schema.
+type flexString string
+
+func (f *flexString) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*f = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*f = flexString(s)
+		return nil
+	}
+	*f = flexString(data)
+	return nil
+}
+
+// depositAccount is the ReBIT FI schema for DEPOSIT accounts. The XML variant
+// carries every leaf as an attribute, the JSON variant as a property.
+type depositAccount struct {
+	Type            string            `json:"type" xml:"type,attr"`
+	MaskedAccNumber string            `json:"maskedAccNumber" xml:"maskedAccNumber,attr"`
+	LinkedAccRef    string            `json:"linkedAccRef" xml:"linkedAccRef,attr"`
+	Version         string            `json:"version" xml:"version,attr"`
+	Profile         depositProfile    `json:"Profile" xml:"Profile"`
+	Summary         depositSummary    `json:"Summary" xml:"Summary"`
+	Transactions    depositTxnsHolder `json:"Transactions" xml:"Transactions"`
+}
+
+type depositProfile struct {
+	Holders depositHolders `json:"Holders" xml:"Holders"`
+}
+
+type depositHolders struct {
+	Type   string          `json:"type" xml:"type,attr"`
+	Holder []depositHolder `json:"Holder" xml:"Holder"`
+}
+
+type depositHolder struct {
+	Name           string `json:"name" xml:"name,attr"`
+	DOB            string `json:"dob" xml:"dob,attr"`
+	Mobile         string `json:"mobile" xml:"mobile,attr"`
+	Nominee        string `json:"nominee" xml:"nominee,attr"`
+	Email          string `json:"email" xml:"email,attr"`
+	PAN            string `json:"pan" xml:"pan,attr"`
+	Address        string `json:"address" xml:"address,attr"`
+	CKYCCompliance bool   `json:"ckycCompliance" xml:"ckycCompliance,attr"`
+}
+
+type depositSummary struct {
+	CurrentBalance  flexString `json:"currentBalance" xml:"currentBalance,attr"`
+	Currency        string     `json:"currency" xml:"currency,attr"`
+	BalanceDateTime string     `json:"balanceDateTime" xml:"balanceDateTime,attr"`
+	Type            string     `json:"type" xml:"type,attr"`
+	Branch          string     `json:"branch" xml:"branch,attr"`
+	IFSCCode        string     `json:"ifscCode" xml:"ifscCode,attr"`
+	MICRCode        string     `json:"micrCode" xml:"micrCode,attr"`
+	OpeningDate     string     `json:"openingDate" xml:"openingDate,attr"`
+	Status          string     `json:"status" xml:"status,attr"`
+}
+
+type depositTxnsHolder struct {
+	StartDate   string           `json:"startDate" xml:"startDate,attr"`
+	EndDate     string           `json:"endDate" xml:"endDate,attr"`
+	Transaction []depositTxnData `json:"Transaction" xml:"Transaction"`
+}
+
+type depositTxnData struct {
+	TxnId                string     `json:"txnId" xml:"txnId,attr"`
+	Type                 string     `json:"type" xml:"type,attr"`
+	Mode                 string     `json:"mode" xml:"mode,attr"`
+	Amount               flexString `json:"amount" xml:"amount,attr"`
+	CurrentBalance       flexString `json:"currentBalance" xml:"currentBalance,attr"`
+	TransactionTimestamp string     `json:"transactionTimestamp" xml:"transactionTimestamp,attr"`
+	ValueDate            string     `json:"valueDate" xml:"valueDate,attr"`
+	Narration            string     `json:"narration" xml:"narration,attr"`
+	Reference            string     `json:"reference" xml:"reference,attr"`
+}
+
+// ParseDepositJSON reads ReBIT DEPOSIT FI data in JSON, either wrapped as
+// {"Account": {...}} or as the bare account object.
+func ParseDepositJSON(r io.Reader) (*Statement, error) {
+	var wrapped struct {
+		Account *depositAccount `json:"account"`
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &wrapped); err != nil {
+		return nil, fmt.Errorf("error decoding FI data: %v", err)
+	}
+
+	account := wrapped.Account
+	if account == nil {
+		account = &depositAccount{}
+		if err := json.Unmarshal(content, account); err != nil {
+			return nil, fmt.Errorf("error decoding FI data: %v", err)
+		}
+	}
+	return account.statement()
+}
+
+// ParseDepositXML reads ReBIT DEPOSIT FI data in XML.
+func ParseDepositXML(r io.Reader) (*Statement, error) {
+	var account depositAccount
+	if err := xml.NewDecoder(r).Decode(&account); err != nil {
+		return nil, fmt.Errorf("error decoding FI data: %v", err)
+	}
+	return account.statement()
+}
+
+// ParseXMLStatement routes an XML file to the camt or ReBIT parser based on
+// its root element.
+func ParseXMLStatement(r io.Reader) (*Statement, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error reading XML root element: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "Document":
+			transactions, err := ParseCAMT(bytes.NewReader(content))
+			if err != nil {
+				return nil, err
+			}
+			return &Statement{Transactions: transactions}, nil
+		case "Account":
+			return ParseDepositXML(bytes.NewReader(content))
+		default:
+			return nil, fmt.Errorf("unsupported XML document <%s>", start.Name.Local)
+		}
+	}
+}
+
+// ParseFIData reads a decrypted FI payload, which FIPs send as either ReBIT
+// XML or JSON.
+func ParseFIData(data []byte) (*Statement, error) {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(trimmed) > 0 && trimmed[0] == '<' {
+		return ParseDepositXML(bytes.NewReader(trimmed))
+	}
+	return ParseDepositJSON(bytes.NewReader(trimmed))
+}
+
+func (a *depositAccount) statement() (*Statement, error) {
+	if a.Type != "" && !strings.EqualFold(a.Type, "deposit") {
+		return nil, fmt.Errorf("unsupported FI type %q", a.Type)
+	}
+
+	accountId := a.LinkedAccRef
+	if accountId == "" {
+		accountId = a.MaskedAccNumber
+	}
+	if accountId == "" {
+		return nil, fmt.Errorf("FI data has neither linkedAccRef nor maskedAccNumber")
+	}
+
+	summary := types.AccountSummary{
+		AccountID:       accountId,
+		MaskedAccNumber: a.MaskedAccNumber,
+		LinkedAccRef:    a.LinkedAccRef,
+		FIType:          "DEPOSIT",
+		AccountType:     a.Summary.Type,
+		HoldingType:     a.Profile.Holders.Type,
+		Currency:        a.Summary.Currency,
+		BalanceDateTime: a.Summary.BalanceDateTime,
+		Branch:          a.Summary.Branch,
+		IFSC:            a.Summary.IFSCCode,
+		MICR:            a.Summary.MICRCode,
+		OpeningDate:     a.Summary.OpeningDate,
+		Status:          a.Summary.Status,
+	}
+	if a.Summary.CurrentBalance != "" {
+		balance, _, err := ParseAmount(string(a.Summary.CurrentBalance))
+		if err != nil {
+			return nil, fmt.Errorf("invalid currentBalance %q", a.Summary.CurrentBalance)
+		}
+		summary.CurrentBalance = balance
+	}
+	for _, h := range a.Profile.Holders.Holder {
+		summary.Holders = append(summary.Holders, types.AccountHolder{
+			Name:           h.Name,
+			DOB:            h.DOB,
+			Mobile:         h.Mobile,
+			Email:          h.Email,
+			PAN:            h.PAN,
+			Address:        h.Address,
+			Nominee:        h.Nominee,
+			CKYCCompliance: h.CKYCCompliance,
+		})
+	}
+
+	statement := &Statement{Accounts: []types.AccountSummary{summary}}
+	for _, txn := range a.Transactions.Transaction {
+		t, err := txn.transaction(accountId)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %v", txn.TxnId, err)
+		}
+		statement.Transactions = append(statement.Transactions, t)
+	}
+	return statement, nil
+}
+
+func (txn depositTxnData) transaction(accountId string) (types.Transaction, error) {
+	amount, _, err := ParseAmount(string(txn.Amount))
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("invalid amount %q", txn.Amount)
+	}
+	amount = math.Abs(amount)
+
+	date := txn.TransactionTimestamp
+	if len(date) >= 10 {
+		date = date[:10]
+	}
+	valueDate := txn.ValueDate
+	if len(valueDate) >= 10 {
+		valueDate = valueDate[:10]
+	}
+	if date == "" {
+		date = valueDate
+	}
+	if date == "" {
+		return types.Transaction{}, fmt.Errorf("no transactionTimestamp or valueDate")
+	}
+
+	t := types.Transaction{
+		AccountID:   accountId,
+		Date:        date,
+		ValueDate:   valueDate,
+		Description: strings.TrimSpace(txn.Narration),
+		ExternalID:  txn.TxnId,
+		Mode:        txn.Mode,
+		Reference:   txn.Reference,
+	}
+
+	switch strings.ToUpper(txn.Type) {
+	case "DEBIT":
+		t.Debit = sql.NullFloat64{Float64: amount, Valid: true}
+	case "CREDIT":
+		t.Credit = sql.NullFloat64{Float64: amount, Valid: true}
+	default:
+		return types.Transaction{}, fmt.Errorf("unknown transaction type %q", txn.Type)
+	}
+
+	if txn.CurrentBalance != "" {
+		balance, _, err := ParseAmount(string(txn.CurrentBalance))
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("invalid currentBalance %q", txn.CurrentBalance)
+		}
+		t.Balance = sql.NullFloat64{Float64: balance, Valid: true}
+	}
+	return t, nil
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"valyx/aggregator/types"
+)
+
+func TestParseFIData(t *testing.T) {
+	for _, test := range []struct {
+		name, data string
+		want       types.Transaction
+	}{
+		{
+			name: "amounts as JSON numbers",
+			data: `{"account": {"linkedAccRef": "hdfc", "Transactions": {"Transaction": [
+				{"txnId": "T1", "type": "CREDIT", "mode": "FT", "amount": 6361.88, "currentBalance": 1006361.88,
+				 "transactionTimestamp": "2023-08-05T10:00:00+05:30", "valueDate": "2023-08-05", "narration": " NEFTIN ", "reference": "R1"}]}}}`,
+			want: types.Transaction{
+				AccountID: "hdfc", Date: "2023-08-05", ValueDate: "2023-08-05", Description: "NEFTIN",
+				Credit: amount(6361.88), Balance: amount(1006361.88), ExternalID: "T1", Mode: "FT", Reference: "R1",
+			},
+		},
+		{
+			name: "amounts as Indian-grouped strings, null reference",
+			data: `{"account": {"linkedAccRef": "hdfc", "Transactions": {"Transaction": [
+				{"txnId": "T2", "type": "debit", "amount": "4,619.46", "currentBalance": "10,01,742.42",
+				 "transactionTimestamp": "2023-08-06T18:30:00+05:30", "valueDate": "2023-08-07", "reference": null}]}}}`,
+			want: types.Transaction{
+				AccountID: "hdfc", Date: "2023-08-06", ValueDate: "2023-08-07",
+				Debit: amount(4619.46), Balance: amount(1001742.42), ExternalID: "T2",
+			},
+		},
+		{
+			name: "bare account object dated by value date, keyed by masked number",
+			data: `{"maskedAccNumber": "XXXXXXXXXX1234", "Transactions": {"Transaction": [
+				{"type": "DEBIT", "amount": "-25", "valueDate": "2023-08-07"}]}}`,
+			want: types.Transaction{AccountID: "XXXXXXXXXX1234", Date: "2023-08-07", ValueDate: "2023-08-07", Debit: amount(25)},
+		},
+		{
+			name: "XML attributes behind a byte order mark",
+			data: "\xef\xbb\xbf" + `<?xml version="1.0"?>
+				<Account xmlns="http://api.rebit.org.in/FISchema/deposit" type="deposit" linkedAccRef="hdfc">
+				<Transactions><Transaction txnId="T3" type="CREDIT" amount="10" currentBalance="110" transactionTimestamp="2023-08-08T09:00:00+05:30" narration="UPI"/></Transactions>
+				</Account>`,
+			want: types.Transaction{
+				AccountID: "hdfc", Date: "2023-08-08", Description: "UPI", Credit: amount(10), Balance: amount(110), ExternalID: "T3",
+			},
+		},
+	} {
+		statement, err := ParseFIData([]byte(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(statement.Transactions) != 1 || !reflect.DeepEqual(statement.Transactions[0], test.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", test.name, statement.Transactions, test.want)
+		}
+	}
+}
+
+func TestParseFIDataSummary(t *testing.T) {
+	for _, data := range []string{
+		`{"account": {"type": "deposit", "maskedAccNumber": "XXXXXXXXXX1234", "linkedAccRef": "hdfc",
+			"Profile": {"Holders": {"type": "SINGLE", "Holder": [{"name": "Rahul Sharma", "pan": "ABCDE1234F", "ckycCompliance": true}]}},
+			"Summary": {"currentBalance": "1001742.42", "currency": "INR", "balanceDateTime": "2023-08-31T23:59:59+05:30",
+				"type": "SAVINGS", "branch": "Koramangala", "ifscCode": "HDFC0001234", "status": "ACTIVE"}}}`,
+		`<Account type="deposit" maskedAccNumber="XXXXXXXXXX1234" linkedAccRef="hdfc">
+			<Profile><Holders type="SINGLE"><Holder name="Rahul Sharma" pan="ABCDE1234F" ckycCompliance="true"/></Holders></Profile>
+			<Summary currentBalance="1001742.42" currency="INR" balanceDateTime="2023-08-31T23:59:59+05:30"
+				type="SAVINGS" branch="Koramangala" ifscCode="HDFC0001234" status="ACTIVE"/>
+		</Account>`,
+	} {
+		statement, err := ParseFIData([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []types.AccountSummary{{
+			AccountID:       "hdfc",
+			MaskedAccNumber: "XXXXXXXXXX1234",
+			LinkedAccRef:    "hdfc",
+			FIType:          "DEPOSIT",
+			AccountType:     "SAVINGS",
+			HoldingType:     "SINGLE",
+			Holders:         []types.AccountHolder{{Name: "Rahul Sharma", PAN: "ABCDE1234F", CKYCCompliance: true}},
+			CurrentBalance:  1001742.42,
+			Currency:        "INR",
+			BalanceDateTime: "2023-08-31T23:59:59+05:30",
+			Branch:          "Koramangala",
+			IFSC:            "HDFC0001234",
+			Status:          "ACTIVE",
+		}}
+		if !reflect.DeepEqual(statement.Accounts, want) {
+			t.Errorf("accounts from %.10q:\ngot  %+v\nwant %+v", data, statement.Accounts, want)
+		}
+	}
+}
+
+func TestParseXMLStatementRoutesByRoot(t *testing.T) {
+	camt := `<Document><BkToCstmrStmt><Stmt><Id>S</Id><Acct><Id><IBAN>X</IBAN></Id></Acct></Stmt></BkToCstmrStmt></Document>`
+	if statement, err := ParseXMLStatement(strings.NewReader(camt)); err != nil || len(statement.Accounts) != 0 {
+		t.Errorf("camt document: %+v, %v", statement, err)
+	}
+
+	rebit := `<Account type="deposit" linkedAccRef="hdfc"/>`
+	if statement, err := ParseXMLStatement(strings.NewReader(rebit)); err != nil || len(statement.Accounts) != 1 {
+		t.Errorf("ReBIT account: %+v, %v", statement, err)
+	}
+
+	if _, err := ParseXMLStatement(strings.NewReader("<Other/>")); err == nil || !strings.Contains(err.Error(), "unsupported XML document <Other>") {
+		t.Errorf("unsupported root: err = %v", err)
+	}
+}
+
+func TestParseFIDataErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, data, reason string
+	}{
+		{"not JSON", "{", "error decoding FI data"},
+		{"no account", `{"account": {"type": "deposit"}}`, "neither linkedAccRef nor maskedAccNumber"},
+		{"other FI type", `{"account": {"type": "mutual_funds", "linkedAccRef": "x"}}`, `unsupported FI type "mutual_funds"`},
+		{"bad balance", `{"account": {"linkedAccRef": "x", "Summary": {"currentBalance": "n/a"}}}`, `invalid currentBalance "n/a"`},
+		{"bad amount", `{"account": {"linkedAccRef": "x", "Transactions": {"Transaction": [{"txnId": "T1", "type": "DEBIT", "amount": "abc", "valueDate": "2023-08-01"}]}}}`, `transaction T1: invalid amount "abc"`},
+		{"bad type", `{"account": {"linkedAccRef": "x", "Transactions": {"Transaction": [{"type": "OTHER", "amount": "1", "valueDate": "2023-08-01"}]}}}`, `unknown transaction type "OTHER"`},
+		{"no date", `{"account": {"linkedAccRef": "x", "Transactions": {"Transaction": [{"type": "DEBIT", "amount": "1"}]}}}`, "no transactionTimestamp or valueDate"},
+		{"XML of another FI type", `<Account type="term_deposit" linkedAccRef="x"/>`, `unsupported FI type "term_deposit"`},
+	} {
+		_, err := ParseFIData([]byte(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: err = %v, want %q", test.name, err, test.reason)
+		}
+	}
+}
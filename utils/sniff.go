@@ -0,0 +1,340 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF8BOM     = "utf-8-bom"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// sniffRows bounds how many lines are inspected when guessing the delimiter
+// and date layout of a file.
+const sniffRows = 20
+
+var candidateDelimiters = []rune{',', ';', '\t', '|'}
+
+// candidateDateLayouts is tried in order, so day-first layouts win over
+// month-first ones when a date is ambiguous.
+var candidateDateLayouts = []string{
+	"02/01/2006", "02/01/06", "02-01-2006", "02-01-06", "02.01.2006",
+	"02-Jan-2006", "02-Jan-06", "02 Jan 2006", "02 Jan 06", "2006-01-02",
+	"2006/01/02", "01/02/2006",
+}
+
+// roleSynonyms maps each column role to the header titles banks use for it.
+// Roles are matched in this order so that "Withdrawal Amount" is claimed as a
+// debit before the generic amount role sees it.
+var roleSynonyms = []struct {
+	role     string
+	synonyms []string
+}{
+	{"date", []string{"date", "txn date", "transaction date", "tran date", "posting date", "value date", "value dt"}},
+	{"debit", []string{"debit", "withdrawal", "withdrawals", "dr", "withdrawal amt", "debit amount", "withdrawal amount"}},
+	{"credit", []string{"credit", "deposit", "deposits", "cr", "deposit amt", "credit amount", "deposit amount"}},
+	{"balance", []string{"balance", "closing balance", "bal", "running balance", "available balance"}},
+	{"amount", []string{"amount", "transaction amount", "txn amount", "amt"}},
+	{"description", []string{"description", "narration", "particulars", "remarks", "details", "transaction remarks", "transaction details"}},
+}
+
+var nonAlnum = regexp.MustCompile(`[^a-z0-9]+`)
+
+// MappingReport explains why the columns of a statement could not be mapped
+// automatically. It is returned as an error so callers can surface it as is.
+type MappingReport struct {
+	File      string            `json:"file"`
+	Encoding  string            `json:"encoding,omitempty"`
+	Delimiter string            `json:"delimiter,omitempty"`
+	HeaderRow int               `json:"headerRow"`
+	Header    []string          `json:"header,omitempty"`
+	Mapped    map[string]string `json:"mapped,omitempty"`
+	Missing   []string          `json:"missing,omitempty"`
+	Reason    string            `json:"reason"`
+}
+
+func (r *MappingReport) Error() string {
+	if len(r.Missing) > 0 {
+		return fmt.Sprintf("could not map %s: %s (missing %s)", r.File, r.Reason, strings.Join(r.Missing, ", "))
+	}
+	return fmt.Sprintf("could not map %s: %s", r.File, r.Reason)
+}
+
+// decodeText detects the encoding of raw file content and returns it as UTF-8.
+func decodeText(content []byte) (string, string, error) {
+	switch {
+	case bytes.HasPrefix(content, []byte{0xEF, 0xBB, 0xBF}):
+		return string(content[3:]), EncodingUTF8BOM, nil
+	case bytes.HasPrefix(content, []byte{0xFF, 0xFE}):
+		return decodeUTF16(content, unicode.LittleEndian, EncodingUTF16LE)
+	case bytes.HasPrefix(content, []byte{0xFE, 0xFF}):
+		return decodeUTF16(content, unicode.BigEndian, EncodingUTF16BE)
+	}
+
+	if endian, ok := looksLikeUTF16(content); ok {
+		if endian == unicode.LittleEndian {
+			return decodeUTF16(content, endian, EncodingUTF16LE)
+		}
+		return decodeUTF16(content, endian, EncodingUTF16BE)
+	}
+
+	if utf8.Valid(content) {
+		return string(content), EncodingUTF8, nil
+	}
+
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(content)
+	if err != nil {
+		return "", "", fmt.Errorf("error decoding windows-1252 content: %v", err)
+	}
+	return string(decoded), EncodingWindows1252, nil
+}
+
+func decodeUTF16(content []byte, endian unicode.Endianness, name string) (string, string, error) {
+	decoded, err := unicode.UTF16(endian, unicode.UseBOM).NewDecoder().Bytes(content)
+	if err != nil {
+		return "", "", fmt.Errorf("error decoding %s content: %v", name, err)
+	}
+	return string(decoded), name, nil
+}
+
+// looksLikeUTF16 spots BOM-less UTF-16 by the zero bytes that ASCII text
+// leaves in every other position.
+func looksLikeUTF16(content []byte) (unicode.Endianness, bool) {
+	sample := content
+	if len(sample) > 512 {
+		sample = sample[:512]
+	}
+	if len(sample) < 4 {
+		return unicode.LittleEndian, false
+	}
+
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+
+	half := len(sample) / 2
+	switch {
+	case oddZeros > half*3/4 && evenZeros == 0:
+		return unicode.LittleEndian, true
+	case evenZeros > half*3/4 && oddZeros == 0:
+		return unicode.BigEndian, true
+	}
+	return unicode.LittleEndian, false
+}
+
+// sniffDelimiter picks the candidate that splits the first lines into the
+// same number of fields most often, preferring more fields on a tie.
+func sniffDelimiter(text string) rune {
+	lines := strings.Split(text, "\n")
+	if len(lines) > sniffRows {
+		lines = lines[:sniffRows]
+	}
+
+	best, bestScore, bestFields := ',', 0, 0
+	for _, delimiter := range candidateDelimiters {
+		counts := make(map[int]int)
+		for _, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			counts[strings.Count(line, string(delimiter))]++
+		}
+
+		for fields, score := range counts {
+			if fields == 0 {
+				continue
+			}
+			if score > bestScore || (score == bestScore && fields > bestFields) {
+				best, bestScore, bestFields = delimiter, score, fields
+			}
+		}
+	}
+	return best
+}
+
+// sniffProfile builds a profile for a file that no configured profile
+// claims, by finding its header row and recognising column titles.
+func sniffProfile(fileName string, rows [][]string) (*StatementProfile, int, error) {
+	report := &MappingReport{File: fileName, HeaderRow: findHeaderRow(rows)}
+	if report.HeaderRow < 0 {
+		report.Reason = "no header row found"
+		if len(rows) > 0 {
+			report.Header = rows[0]
+		}
+		return nil, -1, report
+	}
+
+	header := rows[report.HeaderRow]
+	report.Header = header
+	roles := mapColumnRoles(header)
+
+	report.Mapped = make(map[string]string)
+	for role, idx := range roles {
+		report.Mapped[role] = strings.TrimSpace(header[idx])
+	}
+
+	if _, ok := roles["date"]; !ok {
+		report.Missing = append(report.Missing, "date")
+	}
+	_, hasDebit := roles["debit"]
+	_, hasCredit := roles["credit"]
+	_, hasAmount := roles["amount"]
+	if !hasDebit && !hasCredit && !hasAmount {
+		report.Missing = append(report.Missing, "debit/credit or amount")
+	}
+	if len(report.Missing) > 0 {
+		report.Reason = "required columns not recognised"
+		return nil, -1, report
+	}
+
+	profile := &StatementProfile{
+		Name:           "auto",
+		SignConvention: SignSplit,
+	}
+	if !hasDebit && !hasCredit {
+		profile.SignConvention = SignSigned
+	}
+
+	refs := map[string]*ColumnRef{
+		"date":        &profile.Columns.Date,
+		"description": &profile.Columns.Description,
+		"debit":       &profile.Columns.Debit,
+		"credit":      &profile.Columns.Credit,
+		"amount":      &profile.Columns.Amount,
+		"balance":     &profile.Columns.Balance,
+	}
+	for role, idx := range roles {
+		refs[role].Index = intPtr(idx)
+	}
+
+	layout, ok := sniffDateLayout(rows[report.HeaderRow+1:], roles["date"])
+	if !ok {
+		report.Reason = fmt.Sprintf("dates in column %q are in an unknown format", report.Mapped["date"])
+		return nil, -1, report
+	}
+	profile.DateFormats = []string{layout}
+
+	return profile, report.HeaderRow, nil
+}
+
+// findHeaderRow returns the index of the first row that looks like a statement
+// header, i.e. has a date column and at least two other recognised columns.
+// Rows above it (bank name, address, statement period...) are banner rows.
+func findHeaderRow(rows [][]string) int {
+	for i, row := range rows {
+		if i >= fingerprintScanRows {
+			break
+		}
+		roles := mapColumnRoles(row)
+		if _, ok := roles["date"]; ok && len(roles) >= 3 {
+			return i
+		}
+	}
+	return -1
+}
+
+// mapColumnRoles assigns each header cell to at most one role. Exact synonym
+// matches are preferred over partial ones.
+func mapColumnRoles(header []string) map[string]int {
+	normalized := make([]string, len(header))
+	for i, title := range header {
+		normalized[i] = strings.TrimSpace(nonAlnum.ReplaceAllString(strings.ToLower(title), " "))
+	}
+
+	roles := make(map[string]int)
+	taken := make(map[int]bool)
+	for _, exact := range []bool{true, false} {
+		for _, candidate := range roleSynonyms {
+			if _, ok := roles[candidate.role]; ok {
+				continue
+			}
+			for i, title := range normalized {
+				if taken[i] || title == "" {
+					continue
+				}
+				if matchesSynonym(title, candidate.synonyms, exact) {
+					roles[candidate.role] = i
+					taken[i] = true
+					break
+				}
+			}
+		}
+	}
+	return roles
+}
+
+func matchesSynonym(title string, synonyms []string, exact bool) bool {
+	words := strings.Fields(title)
+	for _, synonym := range synonyms {
+		if exact {
+			if title == synonym {
+				return true
+			}
+			continue
+		}
+		// Short synonyms like "dr" or "cr" must be whole words.
+		if len(synonym) <= 3 {
+			for _, word := range words {
+				if word == synonym {
+					return true
+				}
+			}
+			continue
+		}
+		if strings.Contains(title, synonym) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffDateLayout returns the candidate layout that parses most of the
+// sampled, non-empty values in the date column, if it parses a majority.
+func sniffDateLayout(rows [][]string, col int) (string, bool) {
+	var samples []string
+	for _, row := range rows {
+		if value := cell(row, col); value != "" {
+			samples = append(samples, value)
+		}
+		if len(samples) >= sniffRows {
+			break
+		}
+	}
+	if len(samples) == 0 {
+		return "", false
+	}
+
+	// A few malformed dates should not hide the layout of the rest; they
+	// surface as row errors when the file is read.
+	best, bestMatched := "", 0
+	for _, layout := range candidateDateLayouts {
+		matched := 0
+		for _, sample := range samples {
+			if _, err := parseDate(sample, []string{layout}); err == nil {
+				matched++
+			}
+		}
+		if matched > bestMatched {
+			best, bestMatched = layout, matched
+		}
+	}
+	return best, bestMatched*2 > len(samples)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"valyx/aggregator/types"
+	"valyx/aggregator/utils"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Subfolders of the drop folder that ingested files are moved to.
+const (
+	processedDir = "processed"
+	failedDir    = "failed"
+)
+
+// dropQueueSize is how many settled files may wait for ingestion before the
+// watcher holds back further ones.
+const dropQueueSize = 64
+
+// runWatcher ingests statement files dropped into dir once nothing has been
+// written to them for settle, and then moves them to the processed or failed
+// subfolder. Files already in dir when it starts are picked up too. Settled
+// files are queued for a separate goroutine, so a long import does not hold
+// up the events of other files. It returns once ctx is cancelled and the file
+// being imported is done. An empty dir disables it.
+func runWatcher(ctx context.Context, server *Server, dir string, settle time.Duration) {
+	if dir == "" {
+		log.Println("Statement drop folder disabled")
+		return
+	}
+
+	for _, sub := range []string{processedDir, failedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			log.Printf("watch: %v", err)
+			return
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("watch: error starting watcher: %v", err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		log.Printf("watch: error watching %s: %v", dir, err)
+		return
+	}
+	log.Printf("Watching %s for statements", dir)
+
+	// pending maps each file not yet ingested to the last time it changed.
+	pending := make(map[string]time.Time)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("watch: error reading %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			pending[filepath.Join(dir, entry.Name())] = time.Now()
+		}
+	}
+
+	// Files still queued at shutdown stay in dir for the next start.
+	queue := make(chan string, dropQueueSize)
+	done := make(chan struct{})
+	defer func() {
+		close(queue)
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		for path := range queue {
+			if ctx.Err() == nil {
+				server.ingestDropped(ctx, dir, path)
+			}
+		}
+	}()
+
+	tick := settle / 2
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			switch {
+			case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				pending[event.Name] = time.Now()
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				delete(pending, event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("watch: %v", err)
+		case now := <-ticker.C:
+			for path, changed := range pending {
+				if now.Sub(changed) < settle {
+					continue
+				}
+				select {
+				case queue <- path:
+					delete(pending, path)
+				default:
+					// The queue is full; the file is tried again next tick.
+				}
+			}
+		}
+	}
+}
+
+// ingestDropped imports a file from the drop folder as a one-file ingestion
+// job. Like ./dummyData, the account comes from the statement's header block
+// or else the file name. A file that fails while ctx is cancelled is left in
+// place to be imported again on the next start.
+func (s *Server) ingestDropped(ctx context.Context, dir, path string) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") {
+		return
+	}
+	if !s.Importer.Supports(filepath.Ext(name)) {
+		log.Printf("watch: %s: unsupported statement format", name)
+		if err := moveDropped(path, filepath.Join(dir, failedDir)); err != nil {
+			log.Printf("watch: %v", err)
+		}
+		return
+	}
+
+	job := types.IngestionJob{Status: types.IngestionQueued, Files: 1}
+	if err := s.Ingestions.CreateIngestion(&job); err != nil {
+		log.Printf("watch: %s: %v", name, err)
+		return
+	}
+
+	dest := processedDir
+	for _, result := range s.ingestFiles(ctx, &job, []utils.IngestTask{{Path: path}}) {
+		if result.Error != "" || result.Rejected {
+			dest = failedDir
+		}
+	}
+	if dest == failedDir && ctx.Err() != nil {
+		return
+	}
+	if err := moveDropped(path, filepath.Join(dir, dest)); err != nil {
+		log.Printf("watch: %v", err)
+	}
+}
+
+// moveDropped moves path into dir, prefixing the name with a timestamp if a
+// file of the same name is already there.
+func moveDropped(path, dir string) error {
+	target := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(target); err == nil {
+		target = filepath.Join(dir, time.Now().Format("20060102T150405")+"-"+filepath.Base(path))
+	}
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("error moving %s to %s: %v", path, dir, err)
+	}
+	return nil
+}